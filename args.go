@@ -0,0 +1,57 @@
+package cmdflag
+
+import (
+	"flag"
+	"strings"
+)
+
+// boolFlag is implemented by flag values not requiring an argument, as in the flag package.
+type boolFlag interface {
+	flag.Value
+	IsBoolFlag() bool
+}
+
+// isBoolFlag returns whether the flag does not require an argument.
+func isBoolFlag(f *flag.Flag) bool {
+	b, ok := f.Value.(boolFlag)
+	return ok && b.IsBoolFlag()
+}
+
+// intersperse moves the flags of args found after positional arguments in front of them,
+// so that they can be parsed by fs.
+// Reordering stops at "--" or at the first argument naming a subcommand of c.
+func intersperse(fs *flag.FlagSet, c *Command, args []string) []string {
+	flags := make([]string, 0, len(args)+1)
+	var params []string
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			params = append(params, args[i+1:]...)
+			break
+		}
+		if len(arg) < 2 || arg[0] != '-' {
+			if sub, err := c.lookup(arg); sub != nil || err != nil {
+				params = append(params, args[i:]...)
+				break
+			}
+			params = append(params, arg)
+			continue
+		}
+		flags = append(flags, arg)
+		if takesValue(fs, arg) && i+1 < len(args) {
+			i++
+			flags = append(flags, args[i])
+		}
+	}
+	return append(append(flags, "--"), params...)
+}
+
+// takesValue returns whether the flag argument arg expects its value in the next argument.
+func takesValue(fs *flag.FlagSet, arg string) bool {
+	name := strings.TrimPrefix(arg[1:], "-")
+	if strings.Contains(name, "=") {
+		return false
+	}
+	f := fs.Lookup(name)
+	return f != nil && !isBoolFlag(f)
+}
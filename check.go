@@ -0,0 +1,128 @@
+package cmdflag
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
+// GroupKind defines the constraint applying to a group of flags.
+type GroupKind int
+
+const (
+	// Exclusive flags cannot be set together.
+	Exclusive GroupKind = iota
+	// OneOf flags require exactly one of them to be set.
+	OneOf
+	// Requires flags require all of them to be set when the first one is.
+	Requires
+)
+
+// FlagGroup defines a constraint on a set of flags, checked once they are parsed.
+type FlagGroup struct {
+	Kind  GroupKind
+	Flags []string // Names of the flags in the group
+}
+
+// String describes the constraint of the group.
+func (g FlagGroup) String() string {
+	switch g.Kind {
+	case Exclusive:
+		return "at most one of " + flagNames(g.Flags)
+	case OneOf:
+		return "exactly one of " + flagNames(g.Flags)
+	case Requires:
+		if len(g.Flags) == 0 {
+			break
+		}
+		return fmt.Sprintf("-%s requires %s", g.Flags[0], flagNames(g.Flags[1:]))
+	}
+	return fmt.Sprintf("invalid flag group %d: %s", g.Kind, flagNames(g.Flags))
+}
+
+// check returns the flags of the group preventing it from being satisfied, if any,
+// and whether they are missing or conflicting. The flags are looked up in sets (see checkFlags).
+func (g FlagGroup) check(sets []*flag.FlagSet) (names []string, missing bool) {
+	var set, unset []string
+	for _, name := range g.Flags {
+		if isSet(sets, name) {
+			set = append(set, name)
+		} else {
+			unset = append(unset, name)
+		}
+	}
+	switch g.Kind {
+	case Exclusive, OneOf:
+		if len(set) > 1 {
+			return set, false
+		}
+		if len(set) == 0 && g.Kind == OneOf {
+			return unset, true
+		}
+	case Requires:
+		if len(set) > 0 && set[0] == g.Flags[0] && len(unset) > 0 {
+			return unset, true
+		}
+	}
+	return nil, false
+}
+
+// flagNames returns the dash prefixed flag names separated by commas.
+func flagNames(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return "-" + strings.Join(names, ", -")
+}
+
+// checkFlags verifies that the flags parsed by fs satisfy the constraints of c.
+// The persistent flags of fs may also have been set on the command line of the parent
+// invocations, starting with parent.
+func (c *Command) checkFlags(fs *flag.FlagSet, parent *Invocation) error {
+	sets := []*flag.FlagSet{fs}
+	for ; parent != nil; parent = parent.parent {
+		sets = append(sets, parent.Flags)
+	}
+	app := &c.Application
+	var missing []string
+	for _, name := range app.Required {
+		if !isSet(sets, name) {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return c.parseError(&RequiredFlagsError{Path: c.Path(), Flags: missing})
+	}
+	for _, g := range app.FlagGroups {
+		if names, missing := g.check(sets); len(names) > 0 {
+			return c.parseError(&FlagGroupError{Path: c.Path(), Group: g, Flags: names, Missing: missing})
+		}
+	}
+	return nil
+}
+
+// isSet returns whether the flag name of the first flag set of sets, or one of its aliases,
+// was set in any of them.
+func isSet(sets []*flag.FlagSet, name string) bool {
+	f := sets[0].Lookup(name)
+	if f == nil {
+		return false
+	}
+	var ok bool
+	for _, fs := range sets {
+		fs.Visit(func(set *flag.Flag) {
+			ok = ok || set == f || sameValue(set.Value, f.Value)
+		})
+	}
+	return ok
+}
+
+// isRequired returns whether one of the flags is required by app.
+func (app *Application) isRequired(names []*flag.Flag) bool {
+	for _, f := range names {
+		if hasName(f.Name, app.Required) {
+			return true
+		}
+	}
+	return false
+}
@@ -6,36 +6,96 @@
 package cmdflag
 
 import (
+	"context"
 	"flag"
 	"io"
 	"os"
+	"sort"
+	"strings"
 	"sync"
+	"text/template"
 )
 
 type (
 	// Application defines the attributes of a Command.
 	Application struct {
-		Name  string                      // Command name
-		Descr string                      // Short description
-		Args  string                      // Description of the expected arguments
-		Help  string                      // Displayed when used with the help command
-		Err   flag.ErrorHandling          // Arguments error handling
-		Init  func(*flag.FlagSet) Handler // Initialize the arguments when the command is matched
+		Name         string                      // Command name
+		Aliases      []string                    // Alternative command names
+		Descr        string                      // Short description
+		Args         string                      // Description of the expected arguments
+		Params       []Param                     // Expected arguments, overriding Args
+		Default      bool                        // Run when no command is given at its level
+		Help         string                      // Displayed when used with the help command
+		Group        string                      // Heading the command is listed under in usage and help
+		Err          flag.ErrorHandling          // Arguments error handling
+		Interspersed bool                        // Parse flags found after positional arguments
+		GNU          bool                        // Parse GNU style flags: -o short, --output long and clustered -vxf
+		Required     []string                    // Names of the flags that must be set
+		FlagGroups   []FlagGroup                 // Constraints on sets of flags
+		Init         func(*flag.FlagSet) Handler // Initialize the arguments when the command is matched
+		// Flags declares the command flags. If set, it is called before the flags are parsed,
+		// and Init is only called on the same flag set once they have been successfully parsed.
+		// Usage and help only call it instead of Init to display the flags, so that the
+		// side effects of Init only occur when the command is run.
+		Flags func(*flag.FlagSet)
+		// InitContext is the same as Init but returns a context aware handler.
+		// It takes precedence over Init.
+		InitContext func(*flag.FlagSet) ContextHandler
+		// Before is called before the command handler, once its flags have been parsed.
+		// If set on the top level command, it is called for every command.
+		Before BeforeFunc
+		// After is called once the command handler and its subcommands have run,
+		// even if they failed, provided Before succeeded.
+		// If set on the top level command, it is called for every command.
+		After AfterFunc
 	}
 
+	// BeforeFunc is called with the command about to be run and its remaining arguments.
+	// The command is not run if it returns an error.
+	BeforeFunc func(ctx context.Context, cmd *Command, args []string) error
+
+	// AfterFunc is called with the command that was run and the error it returned, if any.
+	// It returns the error to be reported.
+	AfterFunc func(ctx context.Context, cmd *Command, err error) error
+
 	// Handler is the function called when a matching command is found.
 	// It returns the number of arguments consumed or an error.
 	Handler func(args ...string) (int, error)
 
+	// ContextHandler is the same as Handler but receives the context supplied to Command.ParseContext.
+	ContextHandler func(ctx context.Context, args ...string) (int, error)
+
 	// Command represents a command line command.
 	Command struct {
-		fset *flag.FlagSet
-		mu   sync.Mutex
-		subs []*Command // Commands supported by this command
+		fset   *flag.FlagSet // Top level flags
+		pfset  *flag.FlagSet // Persistent flags
+		mu     sync.Mutex
+		fmu    sync.Mutex // Serializes the accesses to the shared flag values of the top level command
+		parent *Command   // Command this command was added to
+		subs   []*Command // Commands supported by this command
 
 		Application
 		// Usage is the function used to display the usage description.
 		Usage func()
+		// UsageTemplate is the template used by the default usage function of this command and
+		// all its subcommands, unless they set their own. It defaults to DefaultUsageTemplate.
+		UsageTemplate *template.Template
+		// HelpTemplate is the template used by the help command to display this command and
+		// all its subcommands, unless they set their own. It defaults to DefaultHelpTemplate.
+		HelpTemplate *template.Template
+		// AllowPrefix enables matching the subcommands of this command and all its subcommands
+		// by any unambiguous prefix of their name or aliases.
+		AllowPrefix bool
+		// Strict reports unknown and missing subcommands of this command and all its subcommands.
+		// By default, only unknown commands at the top level are reported.
+		Strict bool
+		// HandleSignals makes Parse cancel the context given to the handlers on the first
+		// SIGINT or SIGTERM, and exit the program on the second one with status 128+signal.
+		HandleSignals bool
+		// Recover turns panics in the initializers and handlers of this command and all its
+		// subcommands into a *PanicError. The stack trace is added in debug mode
+		// (see DebugBoolFlag and DebugEnv).
+		Recover bool
 	}
 )
 
@@ -71,23 +131,44 @@ func (c *Command) MustAddHelp() {
 //
 // The command initializer is called only when the command is present on the command line.
 // The handler is called with the remaining arguments once the command flags have been parsed successfully.
+// If the command has parameters, then the handler is only called with their values, once the
+// arguments have been matched against them, and the number of arguments it returns is ignored.
 //
-// Command names must be unique and non empty.
+// Command names and aliases must be unique and non empty, and only one command per level can be the default.
 func (c *Command) Add(app Application) (*Command, error) {
 	if app.Name == "" {
 		return nil, ErrMissingCommandName
 	}
-	if app.Init == nil {
+	for i, alias := range app.Aliases {
+		if alias == "" {
+			return nil, ErrMissingCommandName
+		}
+		if alias == app.Name || hasName(alias, app.Aliases[:i]) {
+			return nil, ErrDuplicateCommand
+		}
+	}
+	if !validParams(app.Params) {
+		return nil, ErrInvalidParams
+	}
+	if app.Init == nil && app.InitContext == nil {
 		return nil, ErrMissingInitializer
 	}
-	sub := &Command{Application: app}
+	sub := &Command{Application: app, parent: c}
 
 	c.mu.Lock()
 	defer c.mu.Unlock()
 	for _, sub := range c.subs {
-		if name := sub.Application.Name; name == app.Name {
+		if sub.Application.isNamed(app.Name) {
 			return nil, ErrDuplicateCommand
 		}
+		for _, alias := range app.Aliases {
+			if sub.Application.isNamed(alias) {
+				return nil, ErrDuplicateCommand
+			}
+		}
+		if app.Default && sub.Application.Default {
+			return nil, ErrDuplicateDefault
+		}
 	}
 	c.subs = append(c.subs, sub)
 	return sub, nil
@@ -107,15 +188,23 @@ func (c *Command) Commands() []*Command {
 	return c.subs
 }
 
-// Output returns the output used for usage. It defaults to os.Stderr.
+// Output returns the output used for usage, the one of the top level flag set.
+// It defaults to os.Stderr.
 func (c *Command) Output() io.Writer {
+	for c.parent != nil {
+		c = c.parent
+	}
 	return fsetOutput(c.fset)
 }
 
 // Parse parses the command line arguments from the argument list, which should not include the command name
 // and including the global flags and, if any, the command and its flags.
 //
-// To be called in lieu of flag.Parse().
+// To be called in lieu of flag.Parse(): the top level flag set is parsed, along with the persistent flags
+// of the top level command that are added to it.
+// The state of a parse is kept apart from the command, so that the same command can be parsed multiple times,
+// including concurrently. The values of the top level and persistent flags are however shared by all the
+// parses: they are set under a lock, and hold the values of the last parse.
 //
 // If no arguments are supplied, it defaults to os.Args[1:].
 // If the VersionBoolFlag is defined as a global boolean flag, then the program version is displayed and the program
@@ -123,75 +212,389 @@ func (c *Command) Output() io.Writer {
 // If the FullVersionBoolFlag is defined as a global boolean flag, then the full program version is displayed and
 // the program stops.
 func (c *Command) Parse(args ...string) error {
+	return c.ParseContext(context.Background(), args...)
+}
+
+// ParseContext is the same as Parse but supplies ctx to the context aware handlers
+// of all the commands found on the command line.
+//
+// If signals are handled by c, then ctx is cancelled on the first SIGINT or SIGTERM
+// and a *SignalError is returned.
+func (c *Command) ParseContext(ctx context.Context, args ...string) error {
+	return c.handleSignals(ctx, func(ctx context.Context) error {
+		return c.parse(ctx, args)
+	})
+}
+
+// parse parses the command line arguments and runs the commands found.
+func (c *Command) parse(ctx context.Context, args []string) error {
+	top, err := c.parseFlags(args)
+	if err != nil {
+		return err
+	}
+
+	// Handle builtin flags.
+	if top.show != nil {
+		top.show(fsetOutput(top.Flags))
+		return nil
+	}
+	if err := c.checkFlags(top.Flags, nil); err != nil {
+		return c.handleError(err)
+	}
+
+	return top.run(ctx, 0)
+}
+
+// parseFlags parses the global flags from the command line arguments
+// and returns the top level invocation.
+//
+// The flag set of the top level command is parsed as flag.Parse does, along with its persistent flags,
+// while holding the lock on the shared flag values, so that c can be parsed concurrently.
+func (c *Command) parseFlags(args []string) (*Invocation, error) {
 	if args == nil {
 		args = os.Args[1:]
 	}
+	unlock := c.lockFlags()
+	defer unlock()
 	fset := c.fset
-	out := fsetOutput(fset)
-
-	// Usage: use the one supplied to c or its fset, or the default one.
-	if c.Usage != nil {
-		fset.Usage = c.Usage
-	} else if fset.Usage == nil {
-		fset.Usage = usage(out, c)
+	if c.parent == nil {
+		c.addPersistentFlags(fset)
+	} else {
+		fset = c.flags()
 	}
+	usage := fset.Usage
+	fset.Usage = c.usage(fsetOutput(fset), fset)
+	defer func() { fset.Usage = usage }()
 
 	// Global flags.
+	if c.Application.GNU {
+		args = expandGNU(fset, c, args, c.Application.Interspersed)
+	}
+	if c.Application.Interspersed {
+		args = intersperse(fset, c, args)
+	}
 	if err := fset.Parse(args); err != nil {
-		return err
+		return nil, c.flagError(err)
 	}
+	// The flag set also reports the flags set by its previous parses.
+	fs := parsedFlags(fset, args)
+	debug := os.Getenv(DebugEnv) != "" || hasBoolFlag(fs, DebugBoolFlag)
+	return &Invocation{Command: c, Flags: fs, Args: fs.Args(), show: builtin(fs), debug: debug}, nil
+}
 
-	// Handle builtin flags.
-	if hasBoolFlag(fset, VersionBoolFlag) {
-		version(out)
-		return nil
+// lockFlags locks the flag values shared by all the parses of the command tree of c,
+// and returns the function unlocking them.
+func (c *Command) lockFlags() func() {
+	for c.parent != nil {
+		c = c.parent
 	}
-	if hasBoolFlag(fset, FullVersionBoolFlag) {
-		fullversion(out)
-		return nil
+	c.fmu.Lock()
+	return c.fmu.Unlock
+}
+
+// flags returns a new flag set with the flags of c, including the persistent ones.
+// For the top level command, they are copied from its flag set.
+func (c *Command) flags() *flag.FlagSet {
+	var fs *flag.FlagSet
+	if c.parent == nil {
+		fs = fsetNew(c.fset)
+		c.fset.VisitAll(func(f *flag.Flag) {
+			copyFlag(fs, f)
+		})
+	} else {
+		fs = flag.NewFlagSet(c.Application.Name, c.Application.Err)
+		c.Application.declare(fs)
 	}
+	c.addPersistentFlags(fs)
+	return fs
+}
 
-	// Only error on the first level.
-	return c.run(0, fset, true)
+// usage returns the function displaying the usage of c with its flags fs:
+// the one supplied to c or to the top level flag set, or the default one.
+func (c *Command) usage(out io.Writer, fs *flag.FlagSet) func() {
+	switch {
+	case c.Usage != nil:
+		return c.Usage
+	case c.parent == nil && c.fset.Usage != nil:
+		return c.fset.Usage
+	}
+	return usage(out, c, fs)
 }
 
-// run a command and its own ones recursively.
-func (c *Command) run(start int, fset *flag.FlagSet, doerror bool) error {
-	// No command.
-	if fset.NArg() == 0 || len(c.subs) == 0 {
-		return nil
+// lookup returns the subcommand of c matching name.
+// If c or one of its parents allows prefixes, then an unambiguous prefix of a subcommand name or alias is also matched.
+// It returns nil if no subcommand matches.
+func (c *Command) lookup(name string) (*Command, error) {
+	for _, sub := range c.subs {
+		if sub.Application.isNamed(name) {
+			return sub, nil
+		}
 	}
+	if !c.allowsPrefix() {
+		return nil, nil
+	}
+	var found []*Command
+	for _, sub := range c.subs {
+		if sub.Application.hasPrefix(name) {
+			found = append(found, sub)
+		}
+	}
+	switch len(found) {
+	case 0:
+		return nil, nil
+	case 1:
+		return found[0], nil
+	}
+	err := &AmbiguousCommandError{Name: name}
+	for _, sub := range found {
+		err.Candidates = append(err.Candidates, sub.Application.Name)
+	}
+	sort.Strings(err.Candidates)
+	return nil, err
+}
 
-	out := fsetOutput(fset)
-	args := fset.Args()[start:]
-	s := args[0]
-	args = args[1:]
+// defaultCommand returns the default subcommand of c, or nil if there is none.
+func (c *Command) defaultCommand() *Command {
 	for _, sub := range c.subs {
-		if sub.Application.Name != s {
-			continue
+		if sub.Application.Default {
+			return sub
 		}
+	}
+	return nil
+}
 
-		fs := flag.NewFlagSet("", sub.Application.Err)
-		fs.SetOutput(out)
-		fs.Usage = usage(out, sub)
-		sub.fset = fs
-		handler := sub.Application.Init(fs)
-		// Command specific arguments.
-		if err := fs.Parse(args); err != nil {
-			return err
+// allowsPrefix returns whether the subcommands of c can be matched by a prefix,
+// as set on c or one of its parents.
+func (c *Command) allowsPrefix() bool {
+	for ; c != nil; c = c.parent {
+		if c.AllowPrefix {
+			return true
 		}
+	}
+	return false
+}
+
+// Path returns the names of the commands leading to c, starting with the program.
+func (c *Command) Path() []string {
+	var path []string
+	for ; c != nil; c = c.parent {
+		path = append([]string{c.Application.Name}, path...)
+	}
+	return path
+}
+
+// init initializes the application flags, unless they are declared separately, and returns its handler.
+func (app *Application) init(fs *flag.FlagSet) ContextHandler {
+	if app.InitContext != nil {
+		return app.InitContext(fs)
+	}
+	handler := app.Init(fs)
+	if handler == nil {
+		return nil
+	}
+	return func(_ context.Context, args ...string) (int, error) {
+		return handler(args...)
+	}
+}
+
+// declare declares the application flags on fs, without initializing its handler if they
+// are declared separately.
+func (app *Application) declare(fs *flag.FlagSet) {
+	if app.Flags != nil {
+		app.Flags(fs)
+		return
+	}
+	_ = app.init(fs)
+}
+
+// isNamed returns whether the application is called name, either by its name or one of its aliases.
+func (app *Application) isNamed(name string) bool {
+	return app.Name == name || hasName(name, app.Aliases)
+}
+
+// hasPrefix returns whether the application name or one of its aliases starts with prefix.
+func (app *Application) hasPrefix(prefix string) bool {
+	if strings.HasPrefix(app.Name, prefix) {
+		return true
+	}
+	for _, alias := range app.Aliases {
+		if strings.HasPrefix(alias, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasName returns whether name is in the list of names.
+func hasName(name string, names []string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// run the subcommand of the invoked command found in its arguments from start,
+// and its own ones recursively.
+func (inv *Invocation) run(ctx context.Context, start int) error {
+	next, err := inv.next(start)
+	if next == nil || err != nil {
+		return err
+	}
+	sub, fs := next.Command, next.Flags
+	var nested error
+	err = sub.withHooks(ctx, fs.Args(), func() error {
 		// Command handler.
-		n, err := handler(args[len(args)-fs.NArg():]...)
+		n, err := sub.handle(ctx, next.handler, fs.Args())
 		if err != nil {
 			return err
 		}
 		// Next command.
-		return sub.run(n, fs, false)
+		nested = next.run(ctx, n)
+		return nested
+	})
+	if err != nil && !sameError(err, nested) {
+		// The error was raised at the subcommand level by its handler or its hooks,
+		// once they have run. Errors of the next commands are already handled.
+		return sub.handleError(err)
 	}
+	return err
+}
 
-	if doerror {
-		return ErrNoCommand
+// next returns the invocation of the subcommand found in the arguments of inv from start,
+// with its flags parsed, or nil if there is none.
+// Unknown commands are only reported at the top level, unless in strict mode where
+// missing ones are also reported.
+// Strict mode applies to a command and all its subcommands once set.
+func (inv *Invocation) next(start int) (*Invocation, error) {
+	c := inv.Command
+	if len(c.subs) == 0 {
+		return nil, nil
 	}
+	strict := inv.strict || c.Strict
+	doerror := c.parent == nil || strict
 
-	return nil
+	args := inv.Flags.Args()
+	if start < len(args) {
+		args = args[start:]
+	} else {
+		args = nil
+	}
+	def := c.defaultCommand()
+	// No command.
+	if len(args) == 0 && def == nil {
+		if strict {
+			return nil, c.handleError(c.parseError(&UnknownCommandError{Path: c.Path()}))
+		}
+		return nil, nil
+	}
+
+	out := fsetOutput(inv.Flags)
+	var sub *Command
+	if len(args) > 0 {
+		s := args[0]
+		var err error
+		sub, err = c.lookup(s)
+		if err != nil {
+			return nil, c.handleError(c.parseError(err))
+		}
+		if sub != nil {
+			args = args[1:]
+		} else if doerror && def != nil {
+			// Report a likely misspelled command instead of passing it to the default one.
+			if suggestions := c.suggest(s); len(suggestions) > 0 {
+				err := &UnknownCommandError{Name: s, Path: c.Path(), Suggestions: suggestions}
+				return nil, c.handleError(c.parseError(err))
+			}
+		}
+	}
+	if sub == nil {
+		// The default command runs with all the remaining arguments.
+		sub = def
+	}
+	if sub == nil {
+		s := args[0]
+		if doerror {
+			err := &UnknownCommandError{Name: s, Path: c.Path(), Suggestions: c.suggest(s)}
+			return nil, c.handleError(c.parseError(err))
+		}
+		return nil, nil
+	}
+
+	fs := flag.NewFlagSet("", sub.Application.Err)
+	fs.SetOutput(out)
+	fs.Usage = sub.usage(out, fs)
+	app := &sub.Application
+	var handler ContextHandler
+	initialize := func() error {
+		return sub.safely(inv.debug, func() error {
+			handler = sub.safeHandler(inv.debug, app.init(fs))
+			return nil
+		})
+	}
+	// Flags declared separately are parsed before the handler is initialized,
+	// so that it is not when only the usage is requested.
+	declare := initialize
+	if app.Flags != nil {
+		declare = func() error {
+			return sub.safely(inv.debug, func() error {
+				app.Flags(fs)
+				return nil
+			})
+		}
+	}
+	if err := declare(); err != nil {
+		return nil, sub.handleError(err)
+	}
+	// Command specific arguments.
+	if err := sub.parseArgs(fs, args); err != nil {
+		return nil, sub.flagError(err)
+	}
+	if err := sub.checkFlags(fs, inv); err != nil {
+		return nil, sub.handleError(err)
+	}
+	if app.Flags != nil {
+		if err := initialize(); err != nil {
+			return nil, sub.handleError(err)
+		}
+	}
+	return &Invocation{
+		Command: sub,
+		Flags:   fs,
+		Args:    fs.Args(),
+		handler: handler,
+		strict:  strict,
+		debug:   inv.debug,
+		parent:  inv,
+	}, nil
+}
+
+// parseArgs parses args with the flags fs of c and its persistent ones,
+// while holding the lock on the shared flag values.
+func (c *Command) parseArgs(fs *flag.FlagSet, args []string) error {
+	unlock := c.lockFlags()
+	defer unlock()
+	c.addPersistentFlags(fs)
+	if c.Application.GNU {
+		args = expandGNU(fs, c, args, c.Application.Interspersed)
+	}
+	if c.Application.Interspersed {
+		args = intersperse(fs, c, args)
+	}
+	return fs.Parse(args)
+}
+
+// handle calls the handler of c with args, or with its parameter values if c has parameters.
+// It returns the number of arguments consumed.
+func (c *Command) handle(ctx context.Context, handler ContextHandler, args []string) (int, error) {
+	if len(c.Application.Params) == 0 {
+		return handler(ctx, args...)
+	}
+	params, n, err := c.parseParams(args)
+	if err != nil {
+		return 0, err
+	}
+	_, err = handler(ctx, params...)
+	return n, err
 }
@@ -2,9 +2,14 @@ package cmdflag_test
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"flag"
+	"fmt"
 	"os"
+	"strings"
 	"testing"
+	"text/template"
 
 	"github.com/pierrec/cmdflag"
 )
@@ -38,6 +43,18 @@ func TestCommand_Add(t *testing.T) {
 			app: apps(cmdflag.Application{Name: "test", Init: ini}, cmdflag.Application{Name: "test", Init: ini})},
 		{label: "cmd1 cmd2",
 			app: apps(cmdflag.Application{Name: "cmd1", Init: ini}, cmdflag.Application{Name: "cmd2", Init: ini})},
+		{label: "missing alias", err: cmdflag.ErrMissingCommandName,
+			app: apps(cmdflag.Application{Name: "test", Aliases: []string{""}, Init: ini})},
+		{label: "alias same as name", err: cmdflag.ErrDuplicateCommand,
+			app: apps(cmdflag.Application{Name: "test", Aliases: []string{"test"}, Init: ini})},
+		{label: "alias duplicate command", err: cmdflag.ErrDuplicateCommand,
+			app: apps(cmdflag.Application{Name: "test", Init: ini}, cmdflag.Application{Name: "cmd", Aliases: []string{"test"}, Init: ini})},
+		{label: "alias duplicate alias", err: cmdflag.ErrDuplicateCommand,
+			app: apps(cmdflag.Application{Name: "cmd1", Aliases: []string{"c"}, Init: ini}, cmdflag.Application{Name: "cmd2", Aliases: []string{"c"}, Init: ini})},
+		{label: "command duplicate alias", err: cmdflag.ErrDuplicateCommand,
+			app: apps(cmdflag.Application{Name: "cmd1", Aliases: []string{"c"}, Init: ini}, cmdflag.Application{Name: "c", Init: ini})},
+		{label: "cmd1 cmd2 aliases",
+			app: apps(cmdflag.Application{Name: "cmd1", Aliases: []string{"c1"}, Init: ini}, cmdflag.Application{Name: "cmd2", Aliases: []string{"c2"}, Init: ini})},
 	} {
 		t.Run(tcase.label, func(t *testing.T) {
 			c := cmdflag.New(nil)
@@ -115,6 +132,1318 @@ func TestInvalidCommand(t *testing.T) {
 	}
 }
 
+func TestUnknownCommandSuggestions(t *testing.T) {
+	defer restoreArgs()()
+
+	ini := func(*flag.FlagSet) cmdflag.Handler { return nil }
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	for _, app := range []cmdflag.Application{
+		{Name: "export", Init: ini},
+		{Name: "expand", Init: ini},
+		{Name: "import", Aliases: []string{"imp"}, Init: ini},
+		{Name: "list", Init: ini},
+	} {
+		if _, err := c.Add(app); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tcase := range []struct {
+		arg  string
+		want []string
+		msg  string
+	}{
+		{"exprot", []string{"export"}, "unknown command `exprot` for `prog`; did you mean `export`?"},
+		{"exp", []string{"expand", "export"}, "unknown command `exp` for `prog`; did you mean one of `expand`, `export`?"},
+		{"im", []string{"import"}, "unknown command `im` for `prog`; did you mean `import`?"},
+		{"zzzzzz", nil, "unknown command `zzzzzz` for `prog`"},
+	} {
+		err := c.Parse(tcase.arg)
+		if !errors.Is(err, cmdflag.ErrNoCommand) {
+			t.Fatalf("%s: got %v; want %v", tcase.arg, err, cmdflag.ErrNoCommand)
+		}
+		var uerr *cmdflag.UnknownCommandError
+		if !errors.As(err, &uerr) {
+			t.Fatalf("%s: got %T; want %T", tcase.arg, err, uerr)
+		}
+		if got, want := strings.Join(uerr.Suggestions, ","), strings.Join(tcase.want, ","); got != want {
+			t.Fatalf("%s: got %s; want %s", tcase.arg, got, want)
+		}
+		if got, want := err.Error(), tcase.msg; got != want {
+			t.Fatalf("got %s; want %s", got, want)
+		}
+	}
+}
+
+func TestStrictCommand(t *testing.T) {
+	defer restoreArgs()()
+
+	consume := func(n int) func(*flag.FlagSet) cmdflag.Handler {
+		return func(*flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) {
+				return n, nil
+			}
+		}
+	}
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	connect := c.MustAdd(cmdflag.Application{Name: "connect", Init: consume(1)})
+	connect.MustAdd(cmdflag.Application{Name: "export", Init: consume(1)})
+
+	for _, args := range [][]string{
+		{"connect", "URL", "exprot", "users"},
+		{"connect", "URL"},
+	} {
+		if err := c.Parse(args...); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+
+	for _, strict := range []*cmdflag.Command{connect, c} {
+		connect.Strict, c.Strict = false, false
+		strict.Strict = true
+
+		err := c.Parse("connect", "URL", "exprot", "users")
+		var uerr *cmdflag.UnknownCommandError
+		if !errors.As(err, &uerr) {
+			t.Fatalf("got %v; want %T", err, uerr)
+		}
+		if got, want := err.Error(), "unknown command `exprot` for `prog connect`; did you mean `export`?"; got != want {
+			t.Fatalf("got %s; want %s", got, want)
+		}
+
+		err = c.Parse("connect", "URL")
+		if !errors.Is(err, cmdflag.ErrNoCommand) {
+			t.Fatalf("got %v; want %v", err, cmdflag.ErrNoCommand)
+		}
+		if got, want := err.Error(), "no command specified for `prog connect`"; got != want {
+			t.Fatalf("got %s; want %s", got, want)
+		}
+
+		// Leaf commands have no subcommands to report.
+		if err := c.Parse("connect", "URL", "export", "users"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDefaultCommand(t *testing.T) {
+	defer restoreArgs()()
+
+	var ran []string
+	record := func(name string, n int) func(*flag.FlagSet) cmdflag.Handler {
+		return func(*flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) {
+				ran = append(ran, name+"("+strings.Join(args, ",")+")")
+				return n, nil
+			}
+		}
+	}
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	c.MustAdd(cmdflag.Application{Name: "status", Default: true, Init: record("status", 0)})
+	connect := c.MustAdd(cmdflag.Application{Name: "connect", Init: record("connect", 1)})
+	connect.MustAdd(cmdflag.Application{Name: "shell", Default: true, Init: record("shell", 0)})
+	connect.MustAdd(cmdflag.Application{Name: "export", Init: record("export", 0)})
+
+	if _, err := c.Add(cmdflag.Application{Name: "other", Default: true, Init: record("other", 0)}); err != cmdflag.ErrDuplicateDefault {
+		t.Fatalf("got %v; want %v", err, cmdflag.ErrDuplicateDefault)
+	}
+
+	for _, tcase := range []struct {
+		args []string
+		want string
+	}{
+		{[]string{}, "status()"},
+		{[]string{"file"}, "status(file)"},
+		{[]string{"status", "file"}, "status(file)"},
+		{[]string{"connect", "URL"}, "connect(URL) shell()"},
+		{[]string{"connect", "URL", "script"}, "connect(URL,script) shell(script)"},
+		{[]string{"connect", "URL", "export", "users"}, "connect(URL,export,users) export(users)"},
+	} {
+		ran = nil
+		if err := c.Parse(tcase.args...); err != nil {
+			t.Fatalf("%v: %v", tcase.args, err)
+		}
+		if got, want := strings.Join(ran, " "), tcase.want; got != want {
+			t.Fatalf("%v: got %s; want %s", tcase.args, got, want)
+		}
+	}
+
+	// Misspelled commands are reported at the top level and in strict mode.
+	for _, tcase := range []struct {
+		args   []string
+		strict bool
+		err    string
+		want   string
+	}{
+		{args: []string{"stauts"}, err: "unknown command `stauts` for `prog`; did you mean `status`?"},
+		{args: []string{"connect", "URL", "exprot"}, want: "connect(URL,exprot) shell(exprot)"},
+		{args: []string{"connect", "URL", "exprot"}, strict: true, err: "unknown command `exprot` for `prog connect`; did you mean `export`?"},
+	} {
+		ran = nil
+		connect.Strict = tcase.strict
+		err := c.Parse(tcase.args...)
+		if tcase.err == "" {
+			if err != nil {
+				t.Fatalf("%v: %v", tcase.args, err)
+			}
+			if got, want := strings.Join(ran, " "), tcase.want; got != want {
+				t.Fatalf("%v: got %s; want %s", tcase.args, got, want)
+			}
+			continue
+		}
+		if !errors.Is(err, cmdflag.ErrNoCommand) || err.Error() != tcase.err {
+			t.Fatalf("%v: got %v; want %s", tcase.args, err, tcase.err)
+		}
+		if len(ran) > 1 {
+			t.Fatalf("%v: default command run: %v", tcase.args, ran)
+		}
+	}
+	connect.Strict = false
+
+	plan, err := c.Resolve("connect", "URL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(plan.Path(), " "), "prog connect shell"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+}
+
+func TestPersistentFlags(t *testing.T) {
+	defer restoreArgs()()
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+
+	c := cmdflag.New(nil)
+	var verbose bool
+	c.PersistentFlags().BoolVar(&verbose, "verbose", false, "verbose mode")
+	connect := c.MustAdd(cmdflag.Application{
+		Name: "connect",
+		Init: func(*flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) {
+				return 1, nil
+			}
+		},
+	})
+	var config string
+	connect.PersistentFlags().StringVar(&config, "config", "", "config file")
+	var table string
+	connect.MustAdd(cmdflag.Application{
+		Name: "export",
+		Init: func(fs *flag.FlagSet) cmdflag.Handler {
+			var output string
+			fs.StringVar(&output, "o", "", "output file")
+			return func(args ...string) (int, error) {
+				table = args[0]
+				return 1, nil
+			}
+		},
+	})
+
+	if err := c.Parse("connect", "URL", "export", "-verbose", "-config", "cfg", "users"); err != nil {
+		t.Fatal(err)
+	}
+	if !verbose {
+		t.Fatal("persistent flag not set")
+	}
+	if got, want := config, "cfg"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+	if got, want := table, "users"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+
+	verbose = false
+	if err := c.Parse("-verbose", "connect", "URL"); err != nil {
+		t.Fatal(err)
+	}
+	if !verbose {
+		t.Fatal("persistent flag not set at the top level")
+	}
+
+	if err := c.Parse("connect", "URL", "export", "-h"); err != flag.ErrHelp {
+		t.Fatalf("got %v; want %v", err, flag.ErrHelp)
+	}
+	out := buf.String()
+	i := strings.Index(out, "Global flags:")
+	if i < 0 {
+		t.Fatalf("missing global flags section: %s", out)
+	}
+	if local := out[:i]; !strings.Contains(local, "-o") || strings.Contains(local, "-verbose") {
+		t.Fatalf("invalid local flags section: %s", local)
+	}
+	if global := out[i:]; !strings.Contains(global, "-verbose") || !strings.Contains(global, "-config") {
+		t.Fatalf("invalid global flags section: %s", global)
+	}
+}
+
+func TestInterspersed(t *testing.T) {
+	defer restoreArgs()()
+
+	flag.CommandLine.SetOutput(new(bytes.Buffer))
+
+	var output string
+	var verbose bool
+	var got []string
+	c := cmdflag.New(nil)
+	c.Interspersed = true
+	flag.BoolVar(&verbose, "v", false, "verbose")
+	export := c.MustAdd(cmdflag.Application{
+		Name:         "export",
+		Interspersed: true,
+		Init: func(fs *flag.FlagSet) cmdflag.Handler {
+			fs.StringVar(&output, "o", "", "output file")
+			return func(args ...string) (int, error) {
+				got = args
+				return len(args), nil
+			}
+		},
+	})
+
+	for _, tcase := range []struct {
+		args         []string
+		interspersed bool
+		output       string
+		got          []string
+	}{
+		{[]string{"export", "users", "-o", "out.csv"}, true, "out.csv", []string{"users"}},
+		{[]string{"export", "users", "-o=out.csv", "groups"}, true, "out.csv", []string{"users", "groups"}},
+		{[]string{"export", "users", "--", "-o", "out.csv"}, true, "", []string{"users", "-o", "out.csv"}},
+		{[]string{"export", "users", "-o", "out.csv"}, false, "", []string{"users", "-o", "out.csv"}},
+		{[]string{"export", "-v", "users"}, true, "", nil},
+	} {
+		output, got = "", nil
+		export.Interspersed = tcase.interspersed
+		err := c.Parse(tcase.args...)
+		if tcase.got == nil {
+			// -v is not a flag of export.
+			if err == nil {
+				t.Fatalf("%v: expected error", tcase.args)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", tcase.args, err)
+		}
+		if output != tcase.output || strings.Join(got, " ") != strings.Join(tcase.got, " ") {
+			t.Fatalf("%v: got %s %v; want %s %v", tcase.args, output, got, tcase.output, tcase.got)
+		}
+	}
+
+	// Global flags stop at the command.
+	export.Interspersed = true
+	if err := c.Parse("export", "users", "-o", "out.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Parse("-v", "export", "users"); err != nil || !verbose {
+		t.Fatalf("global flag not set: %v", err)
+	}
+}
+
+func TestGNUFlags(t *testing.T) {
+	defer restoreArgs()()
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+
+	var v, x, f bool
+	var output string
+	var got []string
+	c := cmdflag.New(nil)
+	export := c.MustAdd(cmdflag.Application{
+		Name: "export",
+		GNU:  true,
+		Init: func(fs *flag.FlagSet) cmdflag.Handler {
+			fs.BoolVar(&v, "v", false, "verbose")
+			fs.BoolVar(&x, "x", false, "extract")
+			fs.BoolVar(&f, "f", false, "force")
+			fs.StringVar(&output, "output", "", "output file")
+			cmdflag.AliasFlag(fs, "o", "output")
+			return func(args ...string) (int, error) {
+				got = args
+				return len(args), nil
+			}
+		},
+	})
+
+	for _, tcase := range []struct {
+		args         []string
+		interspersed bool
+		v, x, f      bool
+		output       string
+		got          string
+	}{
+		{[]string{"-vxf", "users"}, false, true, true, true, "", "users"},
+		{[]string{"-vx", "-o", "out", "users"}, false, true, true, false, "out", "users"},
+		{[]string{"-vofile", "users"}, false, true, false, false, "file", "users"},
+		{[]string{"-vo", "file", "users"}, false, true, false, false, "file", "users"},
+		{[]string{"--output=file", "-f", "users"}, false, false, false, true, "file", "users"},
+		{[]string{"--output", "file", "users", "-vx"}, false, false, false, false, "file", "users -vx"},
+		{[]string{"--output", "file", "users", "-vx"}, true, true, true, false, "file", "users"},
+		{[]string{"users", "--", "-vx"}, true, false, false, false, "", "users -vx"},
+	} {
+		v, x, f, output, got = false, false, false, "", nil
+		export.Interspersed = tcase.interspersed
+		if err := c.Parse(append([]string{"export"}, tcase.args...)...); err != nil {
+			t.Fatalf("%v: %v", tcase.args, err)
+		}
+		if v != tcase.v || x != tcase.x || f != tcase.f || output != tcase.output || strings.Join(got, " ") != tcase.got {
+			t.Fatalf("%v: got %v %v %v %q %v", tcase.args, v, x, f, output, got)
+		}
+	}
+
+	if err := c.Parse("export", "-vz"); err == nil {
+		t.Fatal("expected undefined flag error")
+	}
+
+	buf.Reset()
+	if err := c.Parse("export", "-h"); err != flag.ErrHelp {
+		t.Fatalf("got %v; want %v", err, flag.ErrHelp)
+	}
+	if got, want := buf.String(), "-o, --output string"; !strings.Contains(got, want) {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+}
+
+func TestNestedGNUFlags(t *testing.T) {
+	defer restoreArgs()()
+
+	flag.CommandLine.SetOutput(new(bytes.Buffer))
+	verbose := flag.Bool("v", false, "verbose")
+	flag.String("f", "", "config file")
+
+	var q bool
+	var output string
+	var got []string
+	c := cmdflag.New(nil)
+	c.Application.GNU = true
+	c.Application.Interspersed = true
+	c.MustAdd(cmdflag.Application{
+		Name: "export",
+		GNU:  true,
+		Init: func(fs *flag.FlagSet) cmdflag.Handler {
+			fs.BoolVar(&q, "q", false, "quiet")
+			fs.StringVar(&output, "o", "", "output file")
+			return func(args ...string) (int, error) {
+				got = args
+				return len(args), nil
+			}
+		},
+	})
+
+	for _, tcase := range []struct {
+		args    []string
+		verbose bool
+		got     string
+	}{
+		{[]string{"-v", "export", "-qofile", "users"}, true, "users"},
+		{[]string{"export", "-qo", "file", "users"}, false, "users"},
+		// The top level flags are only parsed before the subcommand.
+		{[]string{"export", "-q", "-ofile", "users", "-v"}, false, "users -v"},
+	} {
+		*verbose, q, output, got = false, false, "", nil
+		if err := c.Parse(tcase.args...); err != nil {
+			t.Fatalf("%v: %v", tcase.args, err)
+		}
+		if *verbose != tcase.verbose || !q || output != "file" || strings.Join(got, " ") != tcase.got {
+			t.Fatalf("%v: got %v %v %q %v", tcase.args, *verbose, q, output, got)
+		}
+	}
+}
+
+func TestRequiredFlags(t *testing.T) {
+	defer restoreArgs()()
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+
+	h := 0
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	c.MustAdd(cmdflag.Application{
+		Name:     "export",
+		Required: []string{"db", "table"},
+		Init: func(fs *flag.FlagSet) cmdflag.Handler {
+			fs.String("db", "", "database")
+			fs.String("table", "", "table")
+			cmdflag.AliasFlag(fs, "t", "table")
+			fs.String("o", "", "output")
+			return func(args ...string) (int, error) {
+				h++
+				return 0, nil
+			}
+		},
+	})
+
+	err := c.Parse("export", "-o", "out")
+	var rerr *cmdflag.RequiredFlagsError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v; want %T", err, rerr)
+	}
+	if got, want := err.Error(), "missing required flags for `prog export`: -db, -table"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+
+	err = c.Parse("export", "-t", "users")
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v; want %T", err, rerr)
+	}
+	if got, want := strings.Join(rerr.Flags, ","), "db"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+	if h != 0 {
+		t.Fatal("handler called with missing flags")
+	}
+
+	if err := c.Parse("export", "-db", "pg", "-t", "users"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := h, 1; got != want {
+		t.Fatalf("got %d; want %d", got, want)
+	}
+
+	if err := c.Parse("export", "-h"); err != flag.ErrHelp {
+		t.Fatalf("got %v; want %v", err, flag.ErrHelp)
+	}
+	if got, want := buf.String(), "database (required)"; !strings.Contains(got, want) {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+	if got := buf.String(); strings.Contains(got, "output (required)") {
+		t.Fatalf("optional flag marked as required: %s", got)
+	}
+}
+
+func TestRequiredPersistentFlags(t *testing.T) {
+	defer restoreArgs()()
+
+	flag.CommandLine.SetOutput(new(bytes.Buffer))
+
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	c.PersistentFlags().String("config", "", "config file")
+	c.PersistentFlags().String("profile", "", "profile")
+	c.MustAdd(cmdflag.Application{
+		Name:       "run",
+		Required:   []string{"config"},
+		FlagGroups: []cmdflag.FlagGroup{{Kind: cmdflag.Exclusive, Flags: []string{"config", "profile"}}},
+		Init: func(fs *flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) { return 0, nil }
+		},
+	})
+
+	for _, args := range [][]string{
+		{"run", "-config", "a"},
+		{"-config", "a", "run"},
+	} {
+		if err := c.Parse(args...); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+		if _, err := c.Resolve(args...); err != nil {
+			t.Fatalf("%v: %v", args, err)
+		}
+	}
+
+	var rerr *cmdflag.RequiredFlagsError
+	if err := c.Parse("run"); !errors.As(err, &rerr) {
+		t.Fatalf("got %v; want %T", err, rerr)
+	}
+	var gerr *cmdflag.FlagGroupError
+	if err := c.Parse("-profile", "p", "run", "-config", "a"); !errors.As(err, &gerr) {
+		t.Fatalf("got %v; want %T", err, gerr)
+	}
+}
+
+func TestFlagGroups(t *testing.T) {
+	defer restoreArgs()()
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	c.MustAdd(cmdflag.Application{
+		Name: "export",
+		FlagGroups: []cmdflag.FlagGroup{
+			{Kind: cmdflag.Exclusive, Flags: []string{"file", "url"}},
+			{Kind: cmdflag.OneOf, Flags: []string{"json", "csv", "table"}},
+			{Kind: cmdflag.Requires, Flags: []string{"user", "password"}},
+		},
+		Init: func(fs *flag.FlagSet) cmdflag.Handler {
+			for _, name := range []string{"file", "url", "user", "password"} {
+				fs.String(name, "", name)
+			}
+			for _, name := range []string{"json", "csv", "table"} {
+				fs.Bool(name, false, name)
+			}
+			return func(args ...string) (int, error) {
+				return 0, nil
+			}
+		},
+	})
+
+	for _, tcase := range []struct {
+		args []string
+		err  string
+	}{
+		{[]string{"-json"}, ""},
+		{[]string{"-csv", "-file", "f", "-user", "u", "-password", "p"}, ""},
+		{[]string{"-table", "-file", "f", "-url", "u"}, "flags -file, -url are mutually exclusive for `prog export`"},
+		{[]string{}, "one of flags -json, -csv, -table is required for `prog export`"},
+		{[]string{"-json", "-table"}, "flags -json, -table are mutually exclusive for `prog export`"},
+		{[]string{"-json", "-user", "u"}, "flag -user requires -password for `prog export`"},
+		{[]string{"-json", "-password", "p"}, ""},
+	} {
+		err := c.Parse(append([]string{"export"}, tcase.args...)...)
+		if tcase.err == "" {
+			if err != nil {
+				t.Fatalf("%v: %v", tcase.args, err)
+			}
+			continue
+		}
+		var gerr *cmdflag.FlagGroupError
+		if !errors.As(err, &gerr) {
+			t.Fatalf("%v: got %v; want %T", tcase.args, err, gerr)
+		}
+		if got, want := err.Error(), tcase.err; got != want {
+			t.Fatalf("%v: got %s; want %s", tcase.args, got, want)
+		}
+	}
+
+	if err := c.Parse("export", "-h"); err != flag.ErrHelp {
+		t.Fatalf("got %v; want %v", err, flag.ErrHelp)
+	}
+	for _, want := range []string{
+		"at most one of -file, -url",
+		"exactly one of -json, -csv, -table",
+		"-user requires -password",
+	} {
+		if got := buf.String(); !strings.Contains(got, want) {
+			t.Fatalf("got %s; want %s", got, want)
+		}
+	}
+}
+
+func TestParams(t *testing.T) {
+	defer restoreArgs()()
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+
+	var got []string
+	handle := func(*flag.FlagSet) cmdflag.Handler {
+		return func(args ...string) (int, error) {
+			got = append(got, strings.Join(args, ","))
+			return 0, nil
+		}
+	}
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	if err := c.AddHelp(); err != nil {
+		t.Fatal(err)
+	}
+	connect := c.MustAdd(cmdflag.Application{
+		Name:   "connect",
+		Params: []cmdflag.Param{{Name: "url"}, {Name: "timeout", Optional: true, Default: "10s"}},
+		Init:   handle,
+	})
+	connect.MustAdd(cmdflag.Application{
+		Name:  "export",
+		Descr: "export a table",
+		Params: []cmdflag.Param{
+			{Name: "table"},
+			{Name: "columns", Variadic: true, Max: 2},
+		},
+		Init: handle,
+	})
+
+	for _, tcase := range []struct {
+		args []string
+		got  []string
+		err  string
+	}{
+		{args: []string{"connect", "URL"}, got: []string{"URL,10s"}},
+		{args: []string{"connect", "URL", "1s"}, got: []string{"URL,1s"}},
+		{args: []string{"connect", "URL", "export", "users"}, got: []string{"URL,10s", "users"}},
+		{args: []string{"connect", "URL", "1s", "export", "users", "a", "b"}, got: []string{"URL,1s", "users,a,b"}},
+		{args: []string{"connect"}, err: "`prog connect` expects 1 to 2 argument(s), got 0"},
+		{args: []string{"connect", "URL", "1s", "x"}, err: "`prog connect` expects 1 to 2 argument(s), got 3"},
+		{args: []string{"connect", "URL", "1s", "x", "export", "users"}, err: "`prog connect` expects 1 to 2 argument(s), got 3"},
+		{args: []string{"connect", "URL", "export"}, err: "`prog connect export` expects 1 to 3 argument(s), got 0"},
+		{args: []string{"connect", "URL", "export", "users", "a", "b", "c"}, err: "`prog connect export` expects 1 to 3 argument(s), got 4"},
+	} {
+		got = nil
+		err := c.Parse(tcase.args...)
+		if tcase.err != "" {
+			var aerr *cmdflag.ArgsError
+			if !errors.As(err, &aerr) {
+				t.Fatalf("%v: got %v; want %T", tcase.args, err, aerr)
+			}
+			if got, want := err.Error(), tcase.err; got != want {
+				t.Fatalf("%v: got %s; want %s", tcase.args, got, want)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%v: %v", tcase.args, err)
+		}
+		if got, want := strings.Join(got, " "), strings.Join(tcase.got, " "); got != want {
+			t.Fatalf("%v: got %s; want %s", tcase.args, got, want)
+		}
+	}
+
+	if _, err := c.Add(cmdflag.Application{
+		Name:   "invalid",
+		Params: []cmdflag.Param{{Name: "opt", Optional: true}, {Name: "req"}},
+		Init:   handle,
+	}); err != cmdflag.ErrInvalidParams {
+		t.Fatalf("got %v; want %v", err, cmdflag.ErrInvalidParams)
+	}
+
+	if err := c.Parse("help", "connect"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "connect url [timeout=10s]"; !strings.Contains(got, want) {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+
+	// The command usage shows its parameters.
+	buf.Reset()
+	if err := c.Parse("connect", "URL", "export", "-h"); err != flag.ErrHelp {
+		t.Fatalf("got %v; want %v", err, flag.ErrHelp)
+	}
+	if got, want := buf.String(), "Usage of command `export`:\nexport a table\nprog connect export table [columns...]\n"; !strings.HasPrefix(got, want) {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+}
+
+func TestParseContext(t *testing.T) {
+	defer restoreArgs()()
+
+	type key struct{}
+	var got []interface{}
+	handle := func(*flag.FlagSet) cmdflag.ContextHandler {
+		return func(ctx context.Context, args ...string) (int, error) {
+			got = append(got, ctx.Value(key{}))
+			return 0, ctx.Err()
+		}
+	}
+	c := cmdflag.New(nil)
+	sub1 := c.MustAdd(cmdflag.Application{Name: "sub1", InitContext: handle})
+	sub2 := sub1.MustAdd(cmdflag.Application{
+		Name: "sub2",
+		Init: func(*flag.FlagSet) cmdflag.Handler {
+			return func(...string) (int, error) { return 0, nil }
+		},
+	})
+	sub2.MustAdd(cmdflag.Application{Name: "sub3", InitContext: handle})
+
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), key{}, "value"))
+	if err := c.ParseContext(ctx, "sub1", "sub2", "sub3"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := fmt.Sprint(got), "[value value]"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+
+	cancel()
+	got = nil
+	if err := c.ParseContext(ctx, "sub1", "sub2", "sub3"); err != context.Canceled {
+		t.Fatalf("got %v; want %v", err, context.Canceled)
+	}
+	if got, want := len(got), 1; got != want {
+		t.Fatalf("got %d; want %d", got, want)
+	}
+}
+
+func TestHooks(t *testing.T) {
+	defer restoreArgs()()
+
+	var events []string
+	before := func(label string) cmdflag.BeforeFunc {
+		return func(_ context.Context, cmd *cmdflag.Command, args []string) error {
+			events = append(events, label+" before "+cmd.Name)
+			if label == "fail" {
+				return errors.New("before failed")
+			}
+			return nil
+		}
+	}
+	after := func(label string) cmdflag.AfterFunc {
+		return func(_ context.Context, cmd *cmdflag.Command, err error) error {
+			events = append(events, fmt.Sprintf("%s after %s %v", label, cmd.Name, err))
+			return err
+		}
+	}
+	handle := func(name string, err error) func(*flag.FlagSet) cmdflag.Handler {
+		return func(*flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) {
+				events = append(events, name)
+				return 1, err
+			}
+		}
+	}
+
+	c := cmdflag.New(nil)
+	c.Before = before("root")
+	c.After = after("root")
+	connect := c.MustAdd(cmdflag.Application{
+		Name:   "connect",
+		Before: before("connect"),
+		After:  after("connect"),
+		Init:   handle("connect", nil),
+	})
+	connect.MustAdd(cmdflag.Application{Name: "export", Init: handle("export", errors.New("export failed"))})
+	c.MustAdd(cmdflag.Application{Name: "fail", Before: before("fail"), After: after("fail"), Init: handle("fail", nil)})
+
+	if err := c.Parse("connect", "URL", "export", "users"); err == nil || err.Error() != "export failed" {
+		t.Fatalf("got %v; want export failed", err)
+	}
+	want := []string{
+		"root before connect",
+		"connect before connect",
+		"connect",
+		"root before export",
+		"export",
+		"root after export export failed",
+		"connect after connect export failed",
+		"root after connect export failed",
+	}
+	if got, want := strings.Join(events, "\n"), strings.Join(want, "\n"); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+
+	events = nil
+	if err := c.Parse("fail"); err == nil || err.Error() != "before failed" {
+		t.Fatalf("got %v; want before failed", err)
+	}
+	want = []string{
+		"root before fail",
+		"fail before fail",
+		"root after fail before failed",
+	}
+	if got, want := strings.Join(events, "\n"), strings.Join(want, "\n"); got != want {
+		t.Fatalf("got\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestResolveExecute(t *testing.T) {
+	defer restoreArgs()()
+
+	var got []string
+	handle := func(name string) func(*flag.FlagSet) cmdflag.Handler {
+		return func(fs *flag.FlagSet) cmdflag.Handler {
+			fs.String("o", "", "output")
+			return func(args ...string) (int, error) {
+				got = append(got, name+" "+strings.Join(args, ","))
+				return 0, nil
+			}
+		}
+	}
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	connect := c.MustAdd(cmdflag.Application{
+		Name:   "connect",
+		Params: []cmdflag.Param{{Name: "url"}},
+		Init:   handle("connect"),
+	})
+	connect.MustAdd(cmdflag.Application{Name: "export", Init: handle("export")})
+	list := c.MustAdd(cmdflag.Application{Name: "list", Init: handle("list")})
+	list.MustAdd(cmdflag.Application{Name: "tables", Init: handle("tables")})
+
+	plan, err := c.Resolve("connect", "URL", "export", "-o", "out", "users", "groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) > 0 {
+		t.Fatalf("handlers called by Resolve: %v", got)
+	}
+	if got, want := strings.Join(plan.Path(), " "), "prog connect export"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+	if got, want := len(plan.Invocations), 3; got != want {
+		t.Fatalf("got %d; want %d", got, want)
+	}
+	if got, want := plan.Invocations[2].Flags.Lookup("o").Value.String(), "out"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+	want := "prog\nprog connect URL\nprog connect export -o=out users groups\n"
+	if got := plan.String(); got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+
+	if err := plan.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(got, "\n"), "connect URL\nexport users,groups"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+
+	// Arguments up to the subcommand.
+	got = nil
+	plan, err = c.Resolve("list", "a", "b", "tables", "c")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := plan.Execute(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(got, "\n"), "list a,b\ntables c"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+
+	if _, err := c.Resolve("lsit"); !errors.Is(err, cmdflag.ErrNoCommand) {
+		t.Fatalf("got %v; want %v", err, cmdflag.ErrNoCommand)
+	}
+}
+
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit %d", int(e)) }
+func (e exitError) ExitCode() int { return int(e) }
+
+func TestExitCode(t *testing.T) {
+	defer restoreArgs()()
+
+	flag.CommandLine.SetOutput(new(bytes.Buffer))
+
+	c := cmdflag.New(nil)
+	c.MustAdd(cmdflag.Application{
+		Name:     "sub",
+		Required: []string{"r"},
+		Init: func(fs *flag.FlagSet) cmdflag.Handler {
+			fs.String("r", "", "required")
+			return func(args ...string) (int, error) {
+				if len(args) == 0 {
+					return 0, nil
+				}
+				switch args[0] {
+				case "fail":
+					return 1, errors.New("failed")
+				case "code":
+					return 1, fmt.Errorf("wrapped: %w", exitError(42))
+				}
+				return 1, nil
+			}
+		},
+	})
+
+	for _, tcase := range []struct {
+		args []string
+		code int
+	}{
+		{[]string{"sub", "-r", "x"}, 0},
+		{[]string{"-h"}, 0},
+		{[]string{"sub", "-h"}, 0},
+		{[]string{"sbu"}, 2},
+		{[]string{"-undefined"}, 2},
+		{[]string{"sub", "-undefined"}, 2},
+		{[]string{"sub"}, 2},
+		{[]string{"sub", "-r", "x", "fail"}, 1},
+		{[]string{"sub", "-r", "x", "code"}, 42},
+	} {
+		err := c.Parse(tcase.args...)
+		if got, want := cmdflag.ExitCode(err), tcase.code; got != want {
+			t.Fatalf("%v: got %d; want %d (%v)", tcase.args, got, want, err)
+		}
+	}
+}
+
+func TestParseError(t *testing.T) {
+	defer restoreArgs()()
+
+	flag.CommandLine.SetOutput(new(bytes.Buffer))
+
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	connect := c.MustAdd(cmdflag.Application{
+		Name: "connect",
+		Init: func(*flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) { return 1, nil }
+		},
+	})
+	connect.MustAdd(cmdflag.Application{
+		Name:     "export",
+		Required: []string{"table"},
+		Init: func(fs *flag.FlagSet) cmdflag.Handler {
+			fs.Int("n", 0, "count")
+			fs.Bool("v", false, "verbose")
+			fs.String("table", "", "table")
+			return func(args ...string) (int, error) { return 0, nil }
+		},
+	})
+
+	for _, tcase := range []struct {
+		args []string
+		path string
+		arg  string
+		msg  string
+	}{
+		{[]string{"connect", "URL", "export", "-x"}, "prog connect export", "-x",
+			"prog connect export: flag provided but not defined: -x"},
+		{[]string{"connect", "URL", "export", "-n", "abc"}, "prog connect export", "-n",
+			""},
+		{[]string{"connect", "URL", "export", "-n", `a" for flag -x: "b`}, "prog connect export", "-n",
+			""},
+		{[]string{"connect", "URL", "export", "-v=maybe"}, "prog connect export", "-v",
+			""},
+		{[]string{"connect", "URL", "export", "-table"}, "prog connect export", "-table",
+			"prog connect export: flag needs an argument: -table"},
+		{[]string{"connect", "URL", "export", "---n"}, "prog connect export", "",
+			"prog connect export: bad flag syntax: ---n"},
+		{[]string{"connect", "URL", "export"}, "prog connect export", "-table",
+			"missing required flags for `prog connect export`: -table"},
+		{[]string{"-x"}, "prog", "-x",
+			"prog: flag provided but not defined: -x"},
+		{[]string{"conect"}, "prog", "conect",
+			"unknown command `conect` for `prog`; did you mean `connect`?"},
+	} {
+		err := c.Parse(tcase.args...)
+		var perr *cmdflag.ParseError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%v: got %v; want %T", tcase.args, err, perr)
+		}
+		if got, want := strings.Join(perr.Path, " "), tcase.path; got != want {
+			t.Fatalf("%v: got %s; want %s", tcase.args, got, want)
+		}
+		if got, want := perr.Arg, tcase.arg; got != want {
+			t.Fatalf("%v: got %s; want %s", tcase.args, got, want)
+		}
+		if got, want := perr.Hint, "run `"+tcase.path+" -h` for usage"; got != want {
+			t.Fatalf("%v: got %s; want %s", tcase.args, got, want)
+		}
+		if tcase.msg != "" && err.Error() != tcase.msg {
+			t.Fatalf("%v: got %s; want %s", tcase.args, err, tcase.msg)
+		}
+	}
+
+	if err := c.Parse("conect"); !errors.Is(err, cmdflag.ErrNoCommand) {
+		t.Fatalf("got %v; want %v", err, cmdflag.ErrNoCommand)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	defer restoreArgs()()
+	defer os.Setenv(cmdflag.DebugEnv, os.Getenv(cmdflag.DebugEnv))
+	os.Setenv(cmdflag.DebugEnv, "")
+
+	debug := flag.Bool(cmdflag.DebugBoolFlag, false, "debug mode")
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	connect := c.MustAdd(cmdflag.Application{
+		Name: "connect",
+		Init: func(*flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) { return 0, nil }
+		},
+	})
+	connect.MustAdd(cmdflag.Application{
+		Name: "export",
+		Init: func(*flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) { panic("export panic") }
+		},
+	})
+	c.MustAdd(cmdflag.Application{
+		Name: "init",
+		Init: func(*flag.FlagSet) cmdflag.Handler { panic("init panic") },
+	})
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Fatal("expected panic without recovery")
+			}
+		}()
+		_ = c.Parse("init")
+	}()
+
+	c.Recover = true
+	for _, tcase := range []struct {
+		args []string
+		msg  string
+	}{
+		{[]string{"init"}, "panic in `prog init`: init panic"},
+		{[]string{"connect", "export"}, "panic in `prog connect export`: export panic"},
+	} {
+		err := c.Parse(tcase.args...)
+		var perr *cmdflag.PanicError
+		if !errors.As(err, &perr) {
+			t.Fatalf("%v: got %v; want %T", tcase.args, err, perr)
+		}
+		if got, want := err.Error(), tcase.msg; got != want {
+			t.Fatalf("%v: got %s; want %s", tcase.args, got, want)
+		}
+		if perr.Stack != nil {
+			t.Fatalf("%v: unexpected stack trace", tcase.args)
+		}
+	}
+
+	err := c.Parse("-"+cmdflag.DebugBoolFlag, "connect", "export")
+	var perr *cmdflag.PanicError
+	if !errors.As(err, &perr) || !*debug {
+		t.Fatalf("got %v; want %T", err, perr)
+	}
+	if !strings.Contains(string(perr.Stack), "TestRecover") {
+		t.Fatalf("missing stack trace: %s", perr.Stack)
+	}
+}
+
+func TestErrorHandling(t *testing.T) {
+	defer restoreArgs()()
+
+	errFailed := errors.New("failed")
+	errHook := errors.New("hook failed")
+	var hookArgs []string
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	connect := c.MustAdd(cmdflag.Application{
+		Name: "connect",
+		Err:  flag.PanicOnError,
+		Before: func(_ context.Context, _ *cmdflag.Command, args []string) error {
+			hookArgs = args
+			if len(args) > 0 && args[0] == "before" {
+				return errHook
+			}
+			return nil
+		},
+		After: func(_ context.Context, _ *cmdflag.Command, err error) error {
+			if len(hookArgs) > 0 && hookArgs[0] == "after" {
+				return errHook
+			}
+			return err
+		},
+		Init: func(*flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) {
+				if len(args) > 0 && args[0] == "fail" {
+					return 0, errFailed
+				}
+				if len(args) > 0 && args[0] == "after" {
+					return 1, nil
+				}
+				return 0, nil
+			}
+		},
+	})
+	connect.MustAdd(cmdflag.Application{
+		Name: "export",
+		Init: func(*flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) { return 0, errFailed }
+		},
+	})
+	connect.Strict = true
+
+	parse := func(args ...string) (err error, panicked interface{}) {
+		defer func() { panicked = recover() }()
+		return c.Parse(args...), nil
+	}
+	for _, tcase := range []struct {
+		args  []string
+		err   error
+		panic error
+	}{
+		// Errors raised at the top level follow its own error handling.
+		{[]string{"dummy"}, cmdflag.ErrNoCommand, nil},
+		// Errors raised at the connect level panic.
+		{[]string{"connect", "fail"}, nil, errFailed},
+		{[]string{"connect", "dummy"}, nil, cmdflag.ErrNoCommand},
+		{[]string{"connect", "before", "export"}, nil, errHook},
+		{[]string{"connect", "after", "export"}, nil, errHook},
+		// Errors raised at the export level are returned.
+		{[]string{"connect", "export"}, errFailed, nil},
+	} {
+		t.Run(strings.Join(tcase.args, " "), func(t *testing.T) {
+			err, p := parse(tcase.args...)
+			if tcase.panic != nil {
+				perr, ok := p.(error)
+				if !ok || !errors.Is(perr, tcase.panic) {
+					t.Fatalf("expected panic with %v; got %v", tcase.panic, p)
+				}
+				return
+			}
+			if p != nil {
+				t.Fatalf("unexpected panic: %v", p)
+			}
+			if !errors.Is(err, tcase.err) {
+				t.Fatalf("expected %v; got %v", tcase.err, err)
+			}
+		})
+	}
+	// Plans follow the same error handling.
+	func() {
+		defer func() {
+			if perr, ok := recover().(error); !ok || !errors.Is(perr, errHook) {
+				t.Fatalf("expected panic with %v; got %v", errHook, perr)
+			}
+		}()
+		plan, err := c.Resolve("connect", "before", "export")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = plan.Execute(context.Background())
+	}()
+
+	// The top level command follows the error handling of its flag set, as for its flags.
+	fset := flag.NewFlagSet("prog", flag.PanicOnError)
+	fset.SetOutput(new(bytes.Buffer))
+	fset.String("user", "", "user name")
+	top := cmdflag.New(fset)
+	top.Application.Name = "prog"
+	top.Application.Required = []string{"user"}
+	top.MustAdd(cmdflag.Application{
+		Name: "connect",
+		Init: func(*flag.FlagSet) cmdflag.Handler { return nil },
+	})
+	for _, tcase := range []struct {
+		args []string
+		msg  string
+	}{
+		{[]string{"-user", "me", "dummy"}, "unknown command `dummy`"},
+		{[]string{"connect"}, "missing required flags for `prog`: -user"},
+	} {
+		func() {
+			defer func() {
+				perr, ok := recover().(error)
+				if !ok || !strings.Contains(perr.Error(), tcase.msg) {
+					t.Fatalf("%v: expected panic with %q; got %v", tcase.args, tcase.msg, perr)
+				}
+			}()
+			_ = top.Parse(tcase.args...)
+		}()
+	}
+}
+
+func TestConcurrentParse(t *testing.T) {
+	defer restoreArgs()()
+
+	flag.CommandLine.SetOutput(new(bytes.Buffer))
+	name := flag.String("name", "", "name")
+
+	type key struct{}
+	c := cmdflag.New(nil)
+	c.Strict = true
+	verbose := c.PersistentFlags().Bool("verbose", false, "verbose mode")
+	connect := c.MustAdd(cmdflag.Application{
+		Name: "connect",
+		InitContext: func(*flag.FlagSet) cmdflag.ContextHandler {
+			return func(ctx context.Context, args ...string) (int, error) {
+				*ctx.Value(key{}).(*string) += args[0]
+				return 1, nil
+			}
+		},
+	})
+	connect.MustAdd(cmdflag.Application{
+		Name: "export",
+		InitContext: func(fs *flag.FlagSet) cmdflag.ContextHandler {
+			var output string
+			fs.StringVar(&output, "o", "", "output file")
+			return func(ctx context.Context, args ...string) (int, error) {
+				*ctx.Value(key{}).(*string) += " " + output + " " + args[0]
+				return 1, nil
+			}
+		},
+	})
+
+	const n = 20
+	errc := make(chan error, 2*n)
+	for i := 0; i < n; i++ {
+		go func(i int) {
+			var got string
+			ctx := context.WithValue(context.Background(), key{}, &got)
+			url, out := fmt.Sprintf("URL%d", i), fmt.Sprintf("out%d", i)
+			err := c.ParseContext(ctx, "-name", url, "connect", url, "export", "-verbose", "-o", out, "users")
+			if want := url + " " + out + " users"; err == nil && got != want {
+				err = fmt.Errorf("got %s; want %s", got, want)
+			}
+			errc <- err
+		}(i)
+		go func(i int) {
+			out := fmt.Sprintf("out%d", i)
+			plan, err := c.Resolve("-name", out, "connect", "-verbose", "URL", "export", "-o", out, "users")
+			if err == nil {
+				if got := plan.Invocations[2].Flags.Lookup("o").Value.String(); got != out {
+					err = fmt.Errorf("got %s; want %s", got, out)
+				}
+			}
+			errc <- err
+		}(i)
+	}
+	for i := 0; i < 2*n; i++ {
+		if err := <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The top level and persistent flag values are shared.
+	if !strings.HasPrefix(*name, "URL") && !strings.HasPrefix(*name, "out") {
+		t.Fatalf("unexpected top level flag value %q", *name)
+	}
+	if !*verbose {
+		t.Fatal("persistent flag not set")
+	}
+}
+
+func TestSequentialParse(t *testing.T) {
+	defer restoreArgs()()
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+	flag.Bool(cmdflag.VersionBoolFlag, false, "display the version")
+
+	var ran bool
+	c := cmdflag.New(nil)
+	c.MustAdd(cmdflag.Application{
+		Name: "x",
+		Init: func(*flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) {
+				ran = true
+				return 0, nil
+			}
+		},
+	})
+
+	if err := c.Parse("-version"); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() == 0 {
+		t.Fatal("version not displayed")
+	}
+	buf.Reset()
+
+	if err := c.Parse("x", "y"); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 || !ran {
+		t.Fatalf("version displayed again: %q", buf.String())
+	}
+	// Parse is called in lieu of flag.Parse.
+	if !flag.Parsed() {
+		t.Fatal("top level flag set not parsed")
+	}
+	if got, want := strings.Join(flag.Args(), " "), "x y"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+
+	// The flags set on the command line are reported by the top level flag set,
+	// along with the ones of the previous parses, as with the flag package.
+	name := flag.String("name", "", "name")
+	if err := c.Parse("-name", "bob", "x"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := *name, "bob"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+	var set []string
+	flag.Visit(func(f *flag.Flag) { set = append(set, f.Name) })
+	if got, want := strings.Join(set, ","), "name,version"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+	if got, want := flag.NFlag(), 2; got != want {
+		t.Fatalf("got %d; want %d", got, want)
+	}
+}
+
 func TestNoCommandSet(t *testing.T) {
 	defer restoreArgs()()
 
@@ -148,6 +1477,129 @@ func TestOneCommand(t *testing.T) {
 	}
 }
 
+func TestCommandAlias(t *testing.T) {
+	defer restoreArgs()()
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+
+	h := 0
+	handle := func(fset *flag.FlagSet) cmdflag.Handler {
+		return func(args ...string) (int, error) {
+			h++
+			return 0, nil
+		}
+	}
+	c := cmdflag.New(nil)
+	if err := c.AddHelp(); err != nil {
+		t.Fatal(err)
+	}
+	app := cmdflag.Application{Name: "remove", Aliases: []string{"rm", "del"}, Init: handle}
+	_, err := c.Add(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"remove", "rm", "del"} {
+		if err := c.Parse(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got, want := h, 3; got != want {
+		t.Fatalf("got %d; want %d", got, want)
+	}
+
+	if err := c.Parse("help", "rm"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "remove (rm, del)"; !strings.Contains(got, want) {
+		t.Fatalf("help does not display the aliases: got %q; want %q", got, want)
+	}
+}
+
+func TestCommandPrefix(t *testing.T) {
+	defer restoreArgs()()
+
+	var got []string
+	handle := func(name string) func(*flag.FlagSet) cmdflag.Handler {
+		return func(fset *flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) {
+				got = append(got, name)
+				return 0, nil
+			}
+		}
+	}
+	c := cmdflag.New(nil)
+	c.AllowPrefix = true
+	for _, name := range []string{"connect", "configure", "con"} {
+		if _, err := c.Add(cmdflag.Application{Name: name, Init: handle(name)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := c.Add(cmdflag.Application{Name: "export", Aliases: []string{"dump"}, Init: handle("export")}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tcase := range []struct {
+		arg  string
+		want string
+	}{
+		{"conn", "connect"},
+		{"conf", "configure"},
+		{"con", "con"},
+		{"exp", "export"},
+		{"du", "export"},
+	} {
+		got = nil
+		if err := c.Parse(tcase.arg); err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0] != tcase.want {
+			t.Fatalf("%s: got %v; want %s", tcase.arg, got, tcase.want)
+		}
+	}
+
+	err := c.Parse("co")
+	var aerr *cmdflag.AmbiguousCommandError
+	if !errors.As(err, &aerr) {
+		t.Fatalf("got %v; want ambiguous command error", err)
+	}
+	if got, want := strings.Join(aerr.Candidates, ","), "con,configure,connect"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+
+	c.AllowPrefix = false
+	if err := c.Parse("exp"); !errors.Is(err, cmdflag.ErrNoCommand) {
+		t.Fatalf("got %v; want %v", err, cmdflag.ErrNoCommand)
+	}
+}
+
+func TestNestedCommandPrefix(t *testing.T) {
+	defer restoreArgs()()
+
+	var got []string
+	handle := func(name string) func(*flag.FlagSet) cmdflag.Handler {
+		return func(fset *flag.FlagSet) cmdflag.Handler {
+			return func(args ...string) (int, error) {
+				got = append(got, name+"("+strings.Join(args, ",")+")")
+				return 0, nil
+			}
+		}
+	}
+	c := cmdflag.New(nil)
+	c.AllowPrefix = true
+	connect := c.MustAdd(cmdflag.Application{Name: "connect", Init: handle("connect")})
+	connect.MustAdd(cmdflag.Application{Name: "export", Init: handle("export")})
+	connect.MustAdd(cmdflag.Application{Name: "import", Init: handle("import")})
+
+	if err := c.Parse("conn", "exp", "users"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := strings.Join(got, " "), "connect(exp,users) export(users)"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+}
+
 func TestOneCommandOneNestedCommand(t *testing.T) {
 	h1 := 0
 	handle1 := func(fset *flag.FlagSet) cmdflag.Handler {
@@ -312,7 +1764,7 @@ func TestVersion(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := c.Parse("-"+cmdflag.VersionBoolFlag+"=false", "dummy"); err != cmdflag.ErrNoCommand {
+	if err := c.Parse("-"+cmdflag.VersionBoolFlag+"=false", "dummy"); !errors.Is(err, cmdflag.ErrNoCommand) {
 		t.Fatal("disabled version flag should error")
 	}
 	if err := c.Parse("-" + cmdflag.VersionBoolFlag); err != nil {
@@ -342,7 +1794,7 @@ func TestFullVersion(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	if err := c.Parse("-"+cmdflag.FullVersionBoolFlag+"=false", "dummy"); err != cmdflag.ErrNoCommand {
+	if err := c.Parse("-"+cmdflag.FullVersionBoolFlag+"=false", "dummy"); !errors.Is(err, cmdflag.ErrNoCommand) {
 		t.Fatal("disabled full version flag should error")
 	}
 
@@ -397,6 +1849,258 @@ func TestHelpCommand(t *testing.T) {
 	}
 }
 
+func TestHelpPath(t *testing.T) {
+	defer restoreArgs()()
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+
+	ini := func(*flag.FlagSet) cmdflag.Handler {
+		return func(args ...string) (int, error) { return 0, nil }
+	}
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	c.PersistentFlags().Bool("verbose", false, "verbose mode")
+	c.MustAddHelp()
+	connect := c.MustAdd(cmdflag.Application{Name: "connect", Descr: "connect to a database", Init: ini})
+	connect.MustAdd(cmdflag.Application{
+		Name:   "export",
+		Descr:  "export tables",
+		Params: []cmdflag.Param{{Name: "table"}},
+		Help:   "Export the table to the output file.",
+		Init: func(fs *flag.FlagSet) cmdflag.Handler {
+			fs.String("o", "", "output `file`")
+			return ini(fs)
+		},
+	})
+	connect.MustAdd(cmdflag.Application{Name: "import", Descr: "import tables", Init: ini})
+
+	if err := c.Parse("help", "connect"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"connect to a database", "prog connect", "Subcommands:", "  export  export tables\n  import  import tables\n"} {
+		if got := buf.String(); !strings.Contains(got, want) {
+			t.Fatalf("got %q; want %q", got, want)
+		}
+	}
+	buf.Reset()
+
+	if err := c.Parse("help", "connect", "export"); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"export tables", "prog connect export table", "Export the table", "-o file", "Global flags:", "-verbose"} {
+		if got := buf.String(); !strings.Contains(got, want) {
+			t.Fatalf("got %q; want %q", got, want)
+		}
+	}
+	buf.Reset()
+
+	err := c.Parse("help", "connect", "exprot")
+	var uerr *cmdflag.UnknownCommandError
+	if !errors.As(err, &uerr) {
+		t.Fatalf("got %v; want %T", err, uerr)
+	}
+	if got, want := err.Error(), "unknown command `exprot` for `prog connect`; did you mean `export`?"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+}
+
+func TestDeclaredFlags(t *testing.T) {
+	defer restoreArgs()()
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+
+	var inits int
+	var output string
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	c.MustAddHelp()
+	c.MustAdd(cmdflag.Application{
+		Name:  "export",
+		Flags: func(fs *flag.FlagSet) { fs.String("o", "out.csv", "output `file`") },
+		Init: func(fs *flag.FlagSet) cmdflag.Handler {
+			inits++
+			return func(args ...string) (int, error) {
+				output = fs.Lookup("o").Value.String()
+				return 0, nil
+			}
+		},
+	})
+
+	if err := c.Parse("-h"); err != flag.ErrHelp {
+		t.Fatalf("got %v; want %v", err, flag.ErrHelp)
+	}
+	if err := c.Parse("help", "export"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "-o file"; !strings.Contains(got, want) {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	if err := c.Parse("export", "-h"); err != flag.ErrHelp {
+		t.Fatalf("got %v; want %v", err, flag.ErrHelp)
+	}
+	if err := c.Parse("export", "-x"); err == nil {
+		t.Fatal("expected undefined flag error")
+	}
+	if inits != 0 {
+		t.Fatalf("initializer called %d time(s) to display the usage", inits)
+	}
+
+	if err := c.Parse("export", "-o", "users.csv"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := inits, 1; got != want {
+		t.Fatalf("got %d; want %d", got, want)
+	}
+	if got, want := output, "users.csv"; got != want {
+		t.Fatalf("got %s; want %s", got, want)
+	}
+}
+
+func TestTemplates(t *testing.T) {
+	defer restoreArgs()()
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+
+	ini := func(*flag.FlagSet) cmdflag.Handler {
+		return func(args ...string) (int, error) { return 0, nil }
+	}
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	c.MustAddHelp()
+	connect := c.MustAdd(cmdflag.Application{
+		Name:  "connect",
+		Descr: "connect to a database",
+		Flags: func(fs *flag.FlagSet) { fs.String("user", "admin", "user `name`") },
+		Init:  ini,
+	})
+	connect.MustAdd(cmdflag.Application{Name: "export", Descr: "export tables", Init: ini})
+
+	c.UsageTemplate = template.Must(template.New("").Parse(
+		"{{.Name}}:{{range .Commands}} {{.Name}}={{.Descr}}{{end}}\n"))
+	if err := c.Parse("-h"); err != flag.ErrHelp {
+		t.Fatalf("got %v; want %v", err, flag.ErrHelp)
+	}
+	if got, want := buf.String(), "prog: connect=connect to a database help=display the help for a given command\n"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	buf.Reset()
+
+	// Subcommands inherit the template, unless they set their own.
+	connect.UsageTemplate = template.Must(template.New("").Parse("{{range .Parents}}{{.}} {{end}}{{.Name}}: {{.Descr}}\n"))
+	if err := c.Parse("connect", "-h"); err != flag.ErrHelp {
+		t.Fatalf("got %v; want %v", err, flag.ErrHelp)
+	}
+	if got, want := buf.String(), "prog connect: connect to a database\n"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+	buf.Reset()
+
+	c.HelpTemplate = template.Must(template.New("").Parse("{{.Name}}{{range .Commands}} [{{.DisplayName}}]{{end}}\n"))
+	if err := c.Parse("help", "connect"); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := buf.String(), "connect [export]\n"; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+}
+
+func TestCommandTable(t *testing.T) {
+	defer restoreArgs()()
+	defer os.Setenv("COLUMNS", os.Getenv("COLUMNS"))
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+
+	var inits int
+	ini := func(fs *flag.FlagSet) cmdflag.Handler {
+		inits++
+		fs.String("o", "", "output")
+		return func(args ...string) (int, error) { return 0, nil }
+	}
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	c.MustAdd(cmdflag.Application{Name: "connect", Aliases: []string{"c"}, Descr: "connect to a database and run the subcommands on it", Init: ini})
+	c.MustAdd(cmdflag.Application{Name: "version", Descr: "display the version", Init: ini})
+	c.MustAdd(cmdflag.Application{Name: "nop", Init: ini})
+
+	for _, tcase := range []struct {
+		columns string
+		want    string
+	}{
+		{"", `
+Subcommands:
+  connect (c)  connect to a database and run the subcommands on it
+  nop
+  version      display the version
+`},
+		{"40", `
+Subcommands:
+  connect (c)  connect to a database and
+               run the subcommands on it
+  nop
+  version      display the version
+`},
+	} {
+		buf.Reset()
+		os.Setenv("COLUMNS", tcase.columns)
+		if err := c.Parse("-h"); err != flag.ErrHelp {
+			t.Fatalf("got %v; want %v", err, flag.ErrHelp)
+		}
+		if got, want := buf.String(), "Usage of prog:\n"+tcase.want; got != want {
+			t.Fatalf("got %q; want %q", got, want)
+		}
+	}
+	if inits != 0 {
+		t.Fatalf("initializer called %d time(s) to list the commands", inits)
+	}
+}
+
+func TestCommandGroups(t *testing.T) {
+	defer restoreArgs()()
+	defer os.Setenv("COLUMNS", os.Getenv("COLUMNS"))
+	os.Setenv("COLUMNS", "")
+
+	buf := new(bytes.Buffer)
+	flag.CommandLine.SetOutput(buf)
+
+	ini := func(*flag.FlagSet) cmdflag.Handler {
+		return func(args ...string) (int, error) { return 0, nil }
+	}
+	c := cmdflag.New(nil)
+	c.Application.Name = "prog"
+	c.MustAdd(cmdflag.Application{Name: "export", Group: "Export", Descr: "export tables", Init: ini})
+	c.MustAdd(cmdflag.Application{Name: "user", Group: "Admin", Descr: "manage users", Init: ini})
+	c.MustAdd(cmdflag.Application{Name: "connect", Group: "Database", Descr: "connect to a database", Init: ini})
+	c.MustAdd(cmdflag.Application{Name: "version", Descr: "display the version", Init: ini})
+	c.MustAdd(cmdflag.Application{Name: "grant", Group: "Admin", Descr: "grant privileges", Init: ini})
+	c.MustAddHelp()
+
+	want := `
+Subcommands:
+  help     display the help for a given command
+  version  display the version
+
+Admin:
+  grant    grant privileges
+  user     manage users
+
+Database:
+  connect  connect to a database
+
+Export:
+  export   export tables
+`
+	if err := c.Parse("-h"); err != flag.ErrHelp {
+		t.Fatalf("got %v; want %v", err, flag.ErrHelp)
+	}
+	if got, want := buf.String(), "Usage of prog:\n"+want; got != want {
+		t.Fatalf("got %q; want %q", got, want)
+	}
+}
+
 func TestHelp(t *testing.T) {
 	defer restoreArgs()()
 
@@ -1,5 +1,11 @@
 package cmdflag
 
+import (
+	"flag"
+	"fmt"
+	"strings"
+)
+
 // Error defines the error type for this package.
 type Error string
 
@@ -16,4 +22,203 @@ const (
 	ErrMissingInitializer Error = "missing command initializer"
 	// ErrDuplicateCommand is returned when a command is redefined.
 	ErrDuplicateCommand Error = "duplicated command"
+	// ErrDuplicateDefault is returned when more than one command of the same level is the default.
+	ErrDuplicateDefault Error = "duplicated default command"
+	// ErrInvalidParams is returned when the parameters of a command are not properly ordered or bounded.
+	ErrInvalidParams Error = "invalid command parameters"
 )
+
+// AmbiguousCommandError is returned when a command prefix matches more than one command.
+type AmbiguousCommandError struct {
+	Name       string   // Command prefix found on the command line
+	Candidates []string // Names of the matching commands
+}
+
+func (e *AmbiguousCommandError) Error() string {
+	return fmt.Sprintf("ambiguous command `%s`, could be one of: %s", e.Name, strings.Join(e.Candidates, ", "))
+}
+
+// UnknownCommandError is returned when a command is not found on the command line.
+// It matches ErrNoCommand with errors.Is.
+type UnknownCommandError struct {
+	Name        string   // Command name found on the command line, empty if none was given
+	Path        []string // Path of the command the unknown one was looked up in
+	Suggestions []string // Names of the closest commands, closest first
+}
+
+func (e *UnknownCommandError) Error() string {
+	path := strings.Join(e.Path, " ")
+	if e.Name == "" {
+		return fmt.Sprintf("%s for `%s`", ErrNoCommand, path)
+	}
+	var buf strings.Builder
+	_, _ = fmt.Fprintf(&buf, "unknown command `%s` for `%s`", e.Name, path)
+	switch len(e.Suggestions) {
+	case 0:
+	case 1:
+		_, _ = fmt.Fprintf(&buf, "; did you mean `%s`?", e.Suggestions[0])
+	default:
+		_, _ = fmt.Fprintf(&buf, "; did you mean one of `%s`?", strings.Join(e.Suggestions, "`, `"))
+	}
+	return buf.String()
+}
+
+// Is makes the error match ErrNoCommand.
+func (e *UnknownCommandError) Is(target error) bool {
+	return target == ErrNoCommand
+}
+
+// RequiredFlagsError is returned when required flags are not set on the command line.
+type RequiredFlagsError struct {
+	Path  []string // Path of the command requiring the flags
+	Flags []string // Names of the missing flags
+}
+
+func (e *RequiredFlagsError) Error() string {
+	return fmt.Sprintf("missing required flags for `%s`: %s", strings.Join(e.Path, " "), flagNames(e.Flags))
+}
+
+// FlagGroupError is returned when the flags set on the command line do not satisfy a flag group.
+type FlagGroupError struct {
+	Path    []string  // Path of the command defining the group
+	Group   FlagGroup // Unsatisfied group
+	Flags   []string  // Names of the conflicting or missing flags
+	Missing bool      // Whether the flags are missing or conflicting
+}
+
+func (e *FlagGroupError) Error() string {
+	path := strings.Join(e.Path, " ")
+	names := flagNames(e.Flags)
+	switch {
+	case !e.Missing:
+		return fmt.Sprintf("flags %s are mutually exclusive for `%s`", names, path)
+	case e.Group.Kind == Requires:
+		return fmt.Sprintf("flag -%s requires %s for `%s`", e.Group.Flags[0], names, path)
+	}
+	return fmt.Sprintf("one of flags %s is required for `%s`", names, path)
+}
+
+// ArgsError is returned when the number of positional arguments does not match the command parameters.
+type ArgsError struct {
+	Path     []string // Path of the command
+	Count    int      // Number of arguments found on the command line
+	Min, Max int      // Bounds on the number of arguments, no upper bound if Max is negative
+}
+
+func (e *ArgsError) Error() string {
+	var expected string
+	switch {
+	case e.Max < 0:
+		expected = fmt.Sprintf("at least %d", e.Min)
+	case e.Min == e.Max:
+		expected = fmt.Sprintf("%d", e.Min)
+	default:
+		expected = fmt.Sprintf("%d to %d", e.Min, e.Max)
+	}
+	return fmt.Sprintf("`%s` expects %s argument(s), got %d", strings.Join(e.Path, " "), expected, e.Count)
+}
+
+// ParseError is returned when the command line arguments of a command cannot be parsed.
+// It wraps the underlying error, which is either returned by the flag package or
+// one of the errors of this package.
+type ParseError struct {
+	Path      []string // Path of the command being parsed
+	Arg       string   // Offending flag or argument, if any
+	Err       error    // Underlying error
+	Hint      string   // How to display the command usage
+	displayed bool     // Whether the error was already displayed by the flag package
+}
+
+func (e *ParseError) Error() string {
+	switch e.Err.(type) {
+	case *UnknownCommandError, *RequiredFlagsError, *FlagGroupError, *ArgsError:
+		// The path is already part of the message.
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", strings.Join(e.Path, " "), e.Err)
+}
+
+// Unwrap returns the underlying error.
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// parseError returns err as a *ParseError for c.
+// flag.ErrHelp is returned as is.
+func (c *Command) parseError(err error) error {
+	if err == nil || err == flag.ErrHelp {
+		return err
+	}
+	path := c.Path()
+	e := &ParseError{
+		Path: path,
+		Err:  err,
+		Hint: fmt.Sprintf("run `%s -h` for usage", strings.Join(path, " ")),
+	}
+	switch err := err.(type) {
+	case *UnknownCommandError:
+		e.Arg = err.Name
+	case *AmbiguousCommandError:
+		e.Arg = err.Name
+	case *RequiredFlagsError:
+		e.Arg = "-" + err.Flags[0]
+	case *FlagGroupError:
+		e.Arg = "-" + err.Flags[0]
+	}
+	return e
+}
+
+// flagError returns the parse error for err returned by the flag package when parsing
+// the flags of c, which has already displayed it.
+func (c *Command) flagError(err error) error {
+	e, ok := c.parseError(err).(*ParseError)
+	if !ok {
+		return err
+	}
+	e.Arg = flagArg(err.Error())
+	e.displayed = true
+	return e
+}
+
+// flagArg returns the flag reported by a flag package error message, or an empty string
+// if the message is not a known one.
+func flagArg(msg string) string {
+	for _, prefix := range []string{"flag provided but not defined: ", "flag needs an argument: "} {
+		if strings.HasPrefix(msg, prefix) {
+			return msg[len(prefix):]
+		}
+	}
+	// invalid value "v" for flag -name: error
+	// invalid boolean value "v" for -name: error
+	for _, prefix := range []string{"invalid value ", "invalid boolean value "} {
+		if !strings.HasPrefix(msg, prefix) {
+			continue
+		}
+		msg = skipQuoted(msg[len(prefix):])
+		for _, prefix := range []string{" for flag -", " for -"} {
+			if strings.HasPrefix(msg, prefix) {
+				msg = msg[len(prefix)-1:]
+				if i := strings.Index(msg, ": "); i > 0 {
+					return msg[:i]
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// skipQuoted returns s without its leading Go quoted string, or an empty string if there is none.
+func skipQuoted(s string) string {
+	if !strings.HasPrefix(s, `"`) {
+		return ""
+	}
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return s[i+1:]
+		}
+	}
+	return ""
+}
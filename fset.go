@@ -10,3 +10,15 @@ import (
 func fsetOutput(fs *flag.FlagSet) io.Writer {
 	return fs.Output()
 }
+
+// fsetNew returns a new empty flag set with the same name, error handling and output as fs.
+func fsetNew(fs *flag.FlagSet) *flag.FlagSet {
+	set := flag.NewFlagSet(fs.Name(), fs.ErrorHandling())
+	set.SetOutput(fs.Output())
+	return set
+}
+
+// fsetErrorHandling returns the error handling of fs.
+func fsetErrorHandling(fs *flag.FlagSet) flag.ErrorHandling {
+	return fs.ErrorHandling()
+}
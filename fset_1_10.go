@@ -11,3 +11,15 @@ import (
 func fsetOutput(fs *flag.FlagSet) io.Writer {
 	return os.Stderr
 }
+
+// fsetNew returns a new empty flag set with the same name, error handling and output as fs.
+// The flag set name and error handling are not available before go1.10.
+func fsetNew(fs *flag.FlagSet) *flag.FlagSet {
+	return flag.NewFlagSet("", flag.ContinueOnError)
+}
+
+// fsetErrorHandling returns the error handling of fs.
+// It is not available before go1.10, so errors are returned.
+func fsetErrorHandling(fs *flag.FlagSet) flag.ErrorHandling {
+	return flag.ContinueOnError
+}
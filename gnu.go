@@ -0,0 +1,76 @@
+package cmdflag
+
+import (
+	"flag"
+	"fmt"
+)
+
+// AliasFlag defines alias as another name for the flag name already defined in fs.
+// Both names share the same value and are displayed together in the usage.
+//
+// It is typically used to give a short name to a long flag for commands using GNU style flags:
+//   fs.StringVar(&output, "output", "", "output file")
+//   cmdflag.AliasFlag(fs, "o", "output")
+//
+// As with the flag package, it panics if the flag cannot be defined.
+func AliasFlag(fs *flag.FlagSet, alias, name string) {
+	f := fs.Lookup(name)
+	if f == nil {
+		panic(fmt.Sprintf("flag %s not defined, cannot alias it to %s", name, alias))
+	}
+	copyFlag(fs, &flag.Flag{Name: alias, Usage: f.Usage, Value: f.Value, DefValue: f.DefValue})
+}
+
+// expandGNU rewrites GNU style short flags from args so that they can be parsed by fs:
+// clustered boolean flags (-vxf) are split (-v -x -f) and values attached to
+// a short flag (-ofile) are separated (-o file).
+// Long flags (--name or --name=value) are natively supported by the flag package.
+//
+// Arguments are processed up to "--", or up to the first positional argument unless all is set,
+// in which case they are processed up to the first subcommand name of c, as its flags are not known to fs.
+func expandGNU(fs *flag.FlagSet, c *Command, args []string, all bool) []string {
+	res := make([]string, 0, len(args))
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		switch {
+		case arg == "--":
+			return append(res, args[i:]...)
+		case len(arg) < 2 || arg[0] != '-':
+			// Positional argument.
+			if !all {
+				return append(res, args[i:]...)
+			}
+			if sub, err := c.lookup(arg); sub != nil || err != nil {
+				return append(res, args[i:]...)
+			}
+			res = append(res, arg)
+			continue
+		case arg[1] == '-' || len(arg) == 2 || arg[2] == '=':
+			// Long flag or single short flag.
+			res = append(res, arg)
+			if takesValue(fs, arg) && i+1 < len(args) {
+				i++
+				res = append(res, args[i])
+			}
+			continue
+		}
+		// Cluster of short flags.
+		for j := 1; j < len(arg); j++ {
+			name := arg[j : j+1]
+			res = append(res, "-"+name)
+			f := fs.Lookup(name)
+			if f == nil || isBoolFlag(f) {
+				continue
+			}
+			if rest := arg[j+1:]; rest != "" {
+				// The remaining of the cluster is the flag value.
+				res = append(res, rest)
+			} else if i+1 < len(args) {
+				i++
+				res = append(res, args[i])
+			}
+			break
+		}
+	}
+	return res
+}
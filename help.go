@@ -3,6 +3,7 @@ package cmdflag
 import (
 	"flag"
 	"fmt"
+	"io"
 )
 
 // HelpCommand is the command name used to display the help of a given command.
@@ -10,6 +11,9 @@ import (
 //
 // To display the help of a command (Application.Help), do:
 //   ./myprogram help commandname
+//
+// Nested commands are reached by their path:
+//   ./myprogram help commandname subcommandname
 const HelpCommand = "help"
 
 // addHelpCommand adds the `help` command to the Command c.
@@ -17,27 +21,43 @@ func addHelpCommand(c *Command) error {
 	app := Application{
 		Name:  HelpCommand,
 		Descr: "display the help for a given command",
-		Args:  "command",
+		Args:  "command...",
 		Init: func(set *flag.FlagSet) Handler {
 			return func(args ...string) (int, error) {
+				out := fsetOutput(set)
 				if len(args) == 0 {
-					c.fset.Usage()
+					unlock := c.lockFlags()
+					fs := c.flags()
+					unlock()
+					c.usage(out, fs)()
 					return 0, nil
 				}
-				name := args[0]
-				out := fsetOutput(set)
-				for _, sub := range c.subs {
-					if sub.Application.Name != name {
-						continue
+				cmd := c
+				for _, name := range args {
+					sub, err := cmd.lookup(name)
+					if err != nil {
+						return len(args), err
+					}
+					if sub == nil {
+						return len(args), &UnknownCommandError{Name: name, Path: cmd.Path(), Suggestions: cmd.suggest(name)}
 					}
-					app := sub.Application
-					_, _ = fmt.Fprintf(out, "%s\n%s %s\n%s\n", app.Descr, app.Name, app.Args, app.Help)
-					return 1, nil
+					cmd = sub
 				}
-				return 1, fmt.Errorf("command %s not found", name)
+				printHelp(out, cmd)
+				return len(args), nil
 			}
 		},
 	}
 	_, err := c.Add(app)
 	return err
 }
+
+// printHelp prints the description, arguments, help, flags and subcommands of c.
+func printHelp(out io.Writer, c *Command) {
+	unlock := c.lockFlags()
+	fs := c.flags()
+	unlock()
+	if err := c.helpTemplate().Execute(out, c.usageData(out, fs)); err != nil {
+		_, _ = fmt.Fprintln(out, err)
+	}
+}
@@ -0,0 +1,41 @@
+package cmdflag
+
+import (
+	"context"
+	"reflect"
+)
+
+// withHooks runs f within the hooks of c and those of the top level command.
+func (c *Command) withHooks(ctx context.Context, args []string, f func() error) error {
+	root := c
+	for root.parent != nil {
+		root = root.parent
+	}
+	f = hook(ctx, c, &c.Application, args, f)
+	if root != c {
+		f = hook(ctx, c, &root.Application, args, f)
+	}
+	return f()
+}
+
+// hook returns a function running f between the Before and After hooks of app.
+func hook(ctx context.Context, c *Command, app *Application, args []string, f func() error) func() error {
+	return func() error {
+		if app.Before != nil {
+			if err := app.Before(ctx, c, args); err != nil {
+				return err
+			}
+		}
+		err := f()
+		if app.After != nil {
+			err = app.After(ctx, c, err)
+		}
+		return err
+	}
+}
+
+// sameError returns whether the errors a and b are the same, e.g. err returned as is by a hook.
+// Errors with non comparable types are never the same.
+func sameError(a, b error) bool {
+	return a != nil && reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Comparable() && a == b
+}
@@ -0,0 +1,92 @@
+package cmdflag
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+// ExitCoder is implemented by errors defining the exit code of the program.
+type ExitCoder interface {
+	ExitCode() int
+}
+
+// Main parses the command line arguments as Parse does and exits the program
+// with the code returned by ExitCode.
+// Errors are displayed on the command output, unless they already were by the flag package.
+//
+// To be called in lieu of flag.Parse() at the end of the main function.
+func (c *Command) Main() {
+	err := c.Parse()
+	c.printError(err)
+	os.Exit(ExitCode(err))
+}
+
+// printError displays err on the command output, unless it already was by the flag package.
+func (c *Command) printError(err error) {
+	name := c.Path()[0]
+	switch e := err.(type) {
+	case nil:
+	case *ParseError:
+		if !e.displayed {
+			_, _ = fmt.Fprintf(c.Output(), "%s: %v\n%s\n", name, err, e.Hint)
+		}
+	default:
+		if err != flag.ErrHelp {
+			_, _ = fmt.Fprintf(c.Output(), "%s: %v\n", name, err)
+		}
+	}
+}
+
+// handleError applies the error handling of c to err raised at its level:
+// the error is returned, or displayed before exiting, or causes a panic.
+// The top level command uses the error handling of its flag set.
+func (c *Command) handleError(err error) error {
+	if err == nil {
+		return nil
+	}
+	handling := c.Application.Err
+	if c.parent == nil {
+		handling = fsetErrorHandling(c.fset)
+	}
+	switch handling {
+	case flag.ExitOnError:
+		c.printError(err)
+		os.Exit(ExitCode(err))
+	case flag.PanicOnError:
+		panic(err)
+	}
+	return err
+}
+
+// ExitCode returns the program exit code for the error returned by Parse:
+//   - 0 if err is nil or flag.ErrHelp
+//   - the code returned by the first error implementing ExitCoder in the chain of wrapped errors
+//   - 2 for command line usage errors (invalid flags, commands or arguments)
+//   - 1 otherwise
+func ExitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	for e := err; e != nil; e = unwrap(e) {
+		switch e := e.(type) {
+		case ExitCoder:
+			return e.ExitCode()
+		case Error, *ParseError, *UnknownCommandError, *AmbiguousCommandError,
+			*RequiredFlagsError, *FlagGroupError, *ArgsError:
+			return 2
+		}
+		if e == flag.ErrHelp {
+			return 0
+		}
+	}
+	return 1
+}
+
+// unwrap returns the error wrapped by err, if any.
+func unwrap(err error) error {
+	if u, ok := err.(interface{ Unwrap() error }); ok {
+		return u.Unwrap()
+	}
+	return nil
+}
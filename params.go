@@ -0,0 +1,127 @@
+package cmdflag
+
+import "strings"
+
+// Param describes a positional argument of a command.
+//
+// Parameters are declared in order: required ones first, then optional ones
+// and finally an optional variadic one.
+type Param struct {
+	Name     string // Name displayed in the usage
+	Optional bool   // The argument may be omitted
+	Default  string // Value of the omitted optional argument
+	Variadic bool   // The argument takes all the remaining ones
+	Min, Max int    // Bounds on the number of arguments taken by a variadic parameter, no upper bound if Max is 0
+}
+
+// String returns the parameter as displayed in the usage synopsis.
+func (p Param) String() string {
+	s := p.Name
+	if p.Variadic {
+		s += "..."
+	}
+	if p.Default != "" {
+		s += "=" + p.Default
+	}
+	if p.Optional || p.Variadic && p.Min == 0 {
+		s = "[" + s + "]"
+	}
+	return s
+}
+
+// validParams returns whether the parameters are properly ordered and bounded.
+func validParams(params []Param) bool {
+	optional := false
+	for i, p := range params {
+		switch {
+		case p.Variadic:
+			if i != len(params)-1 || p.Min < 0 || p.Max < 0 || p.Max > 0 && p.Min > p.Max {
+				return false
+			}
+		case p.Optional:
+			optional = true
+		case optional:
+			// Required parameter after an optional one.
+			return false
+		}
+	}
+	return true
+}
+
+// paramsBounds returns the minimum and maximum number of arguments accepted by params.
+// The maximum is negative if unbounded.
+func paramsBounds(params []Param) (min, max int) {
+	for _, p := range params {
+		switch {
+		case p.Variadic:
+			min += p.Min
+			if p.Max == 0 {
+				return min, -1
+			}
+			max += p.Max
+		case p.Optional:
+			max++
+		default:
+			min++
+			max++
+		}
+	}
+	return
+}
+
+// synopsis returns the description of the arguments expected by the application,
+// generated from its parameters if it has any.
+func (app *Application) synopsis() string {
+	if len(app.Params) == 0 {
+		return app.Args
+	}
+	s := make([]string, len(app.Params))
+	for i, p := range app.Params {
+		s[i] = p.String()
+	}
+	return strings.Join(s, " ")
+}
+
+// parseParams matches the positional arguments args against the parameters of c.
+// The arguments are consumed up to the first subcommand name, if c has subcommands.
+// It returns the parameter values, with defaults for the omitted ones, and the number
+// of arguments consumed.
+func (c *Command) parseParams(args []string) ([]string, int, error) {
+	params := c.Application.Params
+	min, max := paramsBounds(params)
+	n := c.commandIndex(args)
+	if n < min || max >= 0 && n > max {
+		return nil, 0, c.parseError(&ArgsError{Path: c.Path(), Count: n, Min: min, Max: max})
+	}
+
+	values := make([]string, 0, n+len(params))
+	extra := n - min
+	i := 0
+	for _, p := range params {
+		switch {
+		case p.Variadic:
+			values = append(values, args[i:n]...)
+			i = n
+		case p.Optional && extra == 0:
+			values = append(values, p.Default)
+		default:
+			if p.Optional {
+				extra--
+			}
+			values = append(values, args[i])
+			i++
+		}
+	}
+	return values, n, nil
+}
+
+// commandIndex returns the index of the first argument naming a subcommand of c,
+// or the number of arguments if there is none.
+func (c *Command) commandIndex(args []string) int {
+	for i, arg := range args {
+		if sub, err := c.lookup(arg); sub != nil || err != nil {
+			return i
+		}
+	}
+	return len(args)
+}
@@ -0,0 +1,100 @@
+package cmdflag
+
+import (
+	"flag"
+	"io/ioutil"
+	"reflect"
+)
+
+// PersistentFlags returns the flag set used to declare the flags of c that are
+// also accepted by all its subcommands, at any depth.
+//
+// Persistent flags are listed in the "Global flags" section of the subcommands usage.
+func (c *Command) PersistentFlags() *flag.FlagSet {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.pfset == nil {
+		c.pfset = flag.NewFlagSet(c.Application.Name, flag.ContinueOnError)
+	}
+	return c.pfset
+}
+
+// addPersistentFlags adds the persistent flags of c and its parents to fs.
+// Flags already defined in fs take precedence.
+func (c *Command) addPersistentFlags(fs *flag.FlagSet) {
+	for ; c != nil; c = c.parent {
+		if c.pfset == nil {
+			continue
+		}
+		c.pfset.VisitAll(func(f *flag.Flag) {
+			if fs.Lookup(f.Name) == nil {
+				copyFlag(fs, f)
+			}
+		})
+	}
+}
+
+// inherited returns whether f is a persistent flag of one of the parents of c.
+func (c *Command) inherited(f *flag.Flag) bool {
+	for c = c.parent; c != nil; c = c.parent {
+		if c.pfset == nil {
+			continue
+		}
+		if pf := c.pfset.Lookup(f.Name); pf != nil && sameValue(pf.Value, f.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// copyFlag defines f in fs, sharing its value.
+func copyFlag(fs *flag.FlagSet, f *flag.Flag) {
+	fs.Var(f.Value, f.Name, f.Usage)
+	// The value may have been set since it was defined.
+	fs.Lookup(f.Name).DefValue = f.DefValue
+}
+
+// parsedValue is a flag value already set by the parse of its flag set, which ignores being set again.
+type parsedValue struct {
+	flag.Value
+}
+
+func (v parsedValue) Set(string) error { return nil }
+
+func (v parsedValue) IsBoolFlag() bool {
+	b, ok := v.Value.(boolFlag)
+	return ok && b.IsBoolFlag()
+}
+
+func (v parsedValue) Get() interface{} {
+	if g, ok := v.Value.(flag.Getter); ok {
+		return g.Get()
+	}
+	return nil
+}
+
+// parsedFlags returns a copy of fs, just parsed with args, that only reports the flags set by that parse.
+// Its values are the ones of fs.
+func parsedFlags(fs *flag.FlagSet, args []string) *flag.FlagSet {
+	set := fsetNew(fs)
+	fs.VisitAll(func(f *flag.Flag) {
+		copyFlag(set, &flag.Flag{Name: f.Name, Usage: f.Usage, Value: parsedValue{f.Value}, DefValue: f.DefValue})
+	})
+	// The arguments were successfully parsed by fs.
+	set.SetOutput(ioutil.Discard)
+	_ = set.Parse(args)
+	set.SetOutput(fsetOutput(fs))
+	return set
+}
+
+// sameValue returns whether a and b are the same flag value.
+func sameValue(a, b flag.Value) bool {
+	if v, ok := a.(parsedValue); ok {
+		a = v.Value
+	}
+	if v, ok := b.(parsedValue); ok {
+		b = v.Value
+	}
+	// Values with non comparable types (e.g. funcs) would make the comparison panic.
+	return reflect.TypeOf(a) == reflect.TypeOf(b) && reflect.TypeOf(a).Comparable() && a == b
+}
@@ -0,0 +1,131 @@
+package cmdflag
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Invocation is a command found on the command line.
+type Invocation struct {
+	Command *Command      // Command found
+	Flags   *flag.FlagSet // Command flags, parsed
+	Args    []string      // Command arguments, or its parameters values if it has any
+	handler ContextHandler
+	strict  bool            // Strict mode, inherited from the parent commands
+	debug   bool            // Debug mode, set by the environment or the top level flags
+	show    func(io.Writer) // Builtin flag display, set on the top level invocation
+	parent  *Invocation     // Invocation of the parent command, nil for the top level one
+}
+
+// Plan is the list of commands found on the command line, starting with the top level one.
+// It is obtained with Command.Resolve and run with Plan.Execute.
+type Plan struct {
+	Invocations []Invocation
+}
+
+// Resolve parses the command line arguments as Parse does, without running any handler.
+//
+// The commands initializers are called to parse their flags.
+// The arguments of a command are either its parameters, or the arguments up to its first
+// subcommand name, since they cannot be reported by the handler.
+func (c *Command) Resolve(args ...string) (*Plan, error) {
+	top, err := c.parseFlags(args)
+	if err != nil {
+		return nil, err
+	}
+	plan := &Plan{Invocations: []Invocation{*top}}
+	if top.show != nil {
+		return plan, nil
+	}
+	if err := c.checkFlags(top.Flags, nil); err != nil {
+		return nil, c.handleError(err)
+	}
+	if c.defaultCommand() != nil {
+		// The default command receives the arguments not starting with a command.
+		plan.Invocations[0].Args = nil
+	} else {
+		plan.Invocations[0].Args = top.Args[:c.commandIndex(top.Args)]
+	}
+
+	inv, start := top, 0
+	for {
+		next, err := inv.next(start)
+		if err != nil {
+			return nil, err
+		}
+		if next == nil {
+			return plan, nil
+		}
+		if len(next.Command.Application.Params) > 0 {
+			next.Args, start, err = next.Command.parseParams(next.Args)
+			if err != nil {
+				return nil, next.Command.handleError(err)
+			}
+		} else {
+			start = next.Command.commandIndex(next.Args)
+			next.Args = next.Args[:start]
+		}
+		plan.Invocations = append(plan.Invocations, *next)
+		inv = next
+	}
+}
+
+// Path returns the path of the last command of the plan.
+func (p *Plan) Path() []string {
+	return p.Invocations[len(p.Invocations)-1].Command.Path()
+}
+
+// String describes the commands of the plan, one per line, with their flags set and arguments.
+func (p *Plan) String() string {
+	var buf strings.Builder
+	for _, inv := range p.Invocations {
+		buf.WriteString(strings.Join(inv.Command.Path(), " "))
+		inv.Flags.Visit(func(f *flag.Flag) {
+			_, _ = fmt.Fprintf(&buf, " -%s=%s", f.Name, f.Value)
+		})
+		for _, arg := range inv.Args {
+			buf.WriteByte(' ')
+			buf.WriteString(arg)
+		}
+		buf.WriteByte('\n')
+	}
+	return buf.String()
+}
+
+// Execute runs the commands of the plan, as Command.ParseContext does.
+//
+// The handlers are called with the arguments of their invocation and the number of
+// arguments they return is ignored.
+func (p *Plan) Execute(ctx context.Context) error {
+	top := p.Invocations[0]
+	if top.show != nil {
+		top.show(fsetOutput(top.Flags))
+		return nil
+	}
+	return top.Command.handleSignals(ctx, func(ctx context.Context) error {
+		return execute(ctx, p.Invocations[1:])
+	})
+}
+
+// execute runs the invocations in order, each one within the hooks of the previous ones.
+func execute(ctx context.Context, invs []Invocation) error {
+	if len(invs) == 0 {
+		return nil
+	}
+	inv := invs[0]
+	var nested error
+	err := inv.Command.withHooks(ctx, inv.Args, func() error {
+		if _, err := inv.handler(ctx, inv.Args...); err != nil {
+			return err
+		}
+		nested = execute(ctx, invs[1:])
+		return nested
+	})
+	if err != nil && !sameError(err, nested) {
+		return inv.Command.handleError(err)
+	}
+	return err
+}
@@ -0,0 +1,75 @@
+package cmdflag
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"strings"
+)
+
+const (
+	// DebugBoolFlag is the flag name to be used as a boolean flag to add the stack trace to the
+	// errors of recovered panics (see Command.Recover).
+	DebugBoolFlag = "debug"
+	// DebugEnv is the environment variable enabling the stack trace in the errors of recovered
+	// panics when set to a non empty value (see Command.Recover).
+	DebugEnv = "CMDFLAG_DEBUG"
+)
+
+// PanicError is returned when a panic occurred in a command initializer or handler
+// and was recovered (see Command.Recover).
+type PanicError struct {
+	Path  []string    // Path of the command that panicked
+	Value interface{} // Value passed to panic
+	Stack []byte      // Stack trace, only set in debug mode
+}
+
+func (e *PanicError) Error() string {
+	msg := fmt.Sprintf("panic in `%s`: %v", strings.Join(e.Path, " "), e.Value)
+	if len(e.Stack) == 0 {
+		return msg
+	}
+	return msg + "\n" + string(e.Stack)
+}
+
+// recovers returns whether panics are recovered for c, which is inherited from its parents.
+func (c *Command) recovers() bool {
+	for ; c != nil; c = c.parent {
+		if c.Recover {
+			return true
+		}
+	}
+	return false
+}
+
+// safely calls f and turns a panic into a *PanicError if c recovers from panics.
+// The stack trace is added in debug mode.
+func (c *Command) safely(debugMode bool, f func() error) (err error) {
+	if !c.recovers() {
+		return f()
+	}
+	defer func() {
+		if v := recover(); v != nil {
+			e := &PanicError{Path: c.Path(), Value: v}
+			if debugMode {
+				e.Stack = debug.Stack()
+			}
+			err = e
+		}
+	}()
+	return f()
+}
+
+// safeHandler returns a handler turning panics in handler into a *PanicError if c recovers from panics.
+func (c *Command) safeHandler(debugMode bool, handler ContextHandler) ContextHandler {
+	if !c.recovers() {
+		return handler
+	}
+	return func(ctx context.Context, args ...string) (n int, err error) {
+		err = c.safely(debugMode, func() error {
+			n, err = handler(ctx, args...)
+			return err
+		})
+		return
+	}
+}
@@ -0,0 +1,116 @@
+package cmdflag
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// SignalError is returned when the commands were interrupted by a signal (see Command.HandleSignals).
+type SignalError struct {
+	Signal os.Signal
+	Err    error // Error returned by the interrupted command, if any
+}
+
+func (e *SignalError) Error() string {
+	if e.Err == nil {
+		return fmt.Sprintf("interrupted by signal %v", e.Signal)
+	}
+	return fmt.Sprintf("interrupted by signal %v: %v", e.Signal, e.Err)
+}
+
+// Unwrap returns the error returned by the interrupted command.
+func (e *SignalError) Unwrap() error {
+	return e.Err
+}
+
+// ExitCode returns the conventional exit code for a program terminated by the signal.
+func (e *SignalError) ExitCode() int {
+	return 128 + signum(e.Signal)
+}
+
+// Interrupted returns the signal that cancelled the context given to the handlers, or nil if none.
+func Interrupted(ctx context.Context) os.Signal {
+	if s, ok := ctx.Value(signalKey{}).(*signalState); ok {
+		return s.get()
+	}
+	return nil
+}
+
+// handleSignals runs f with a context cancelled on signals, if c handles them.
+func (c *Command) handleSignals(ctx context.Context, f func(context.Context) error) error {
+	if !c.HandleSignals {
+		return f(ctx)
+	}
+	ctx, stop := withSignals(ctx)
+	err := f(ctx)
+	if sig := stop(); sig != nil {
+		return &SignalError{Signal: sig, Err: err}
+	}
+	return err
+}
+
+type signalKey struct{}
+
+// signalState records the first signal received.
+type signalState struct {
+	mu  sync.Mutex
+	sig os.Signal
+}
+
+func (s *signalState) set(sig os.Signal) {
+	s.mu.Lock()
+	s.sig = sig
+	s.mu.Unlock()
+}
+
+func (s *signalState) get() os.Signal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sig
+}
+
+// withSignals returns a context cancelled on the first SIGINT or SIGTERM.
+// The program exits on the second one.
+// The returned stop function stops handling signals and returns the one that was received, if any.
+func withSignals(ctx context.Context) (context.Context, func() os.Signal) {
+	ctx, cancel := context.WithCancel(ctx)
+	state := new(signalState)
+	ctx = context.WithValue(ctx, signalKey{}, state)
+
+	sigc := make(chan os.Signal, 2)
+	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
+	done := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-sigc:
+			state.set(sig)
+			cancel()
+		case <-done:
+			return
+		}
+		select {
+		case sig := <-sigc:
+			os.Exit(128 + signum(sig))
+		case <-done:
+		}
+	}()
+
+	return ctx, func() os.Signal {
+		signal.Stop(sigc)
+		close(done)
+		cancel()
+		return state.get()
+	}
+}
+
+// signum returns the number of the signal, or 1 if unknown.
+func signum(sig os.Signal) int {
+	if s, ok := sig.(syscall.Signal); ok {
+		return int(s)
+	}
+	return 1
+}
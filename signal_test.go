@@ -0,0 +1,63 @@
+// +build !windows
+
+package cmdflag_test
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/pierrec/cmdflag"
+)
+
+func TestHandleSignals(t *testing.T) {
+	defer restoreArgs()()
+
+	var sig os.Signal
+	c := cmdflag.New(nil)
+	c.HandleSignals = true
+	c.MustAdd(cmdflag.Application{
+		Name: "wait",
+		InitContext: func(*flag.FlagSet) cmdflag.ContextHandler {
+			return func(ctx context.Context, args ...string) (int, error) {
+				if err := syscall.Kill(os.Getpid(), syscall.SIGINT); err != nil {
+					return 0, err
+				}
+				select {
+				case <-ctx.Done():
+				case <-time.After(5 * time.Second):
+					return 0, errors.New("context not cancelled")
+				}
+				sig = cmdflag.Interrupted(ctx)
+				return 0, ctx.Err()
+			}
+		},
+	})
+
+	err := c.Parse("wait")
+	var serr *cmdflag.SignalError
+	if !errors.As(err, &serr) {
+		t.Fatalf("got %v; want %T", err, serr)
+	}
+	if got, want := serr.Signal, os.Interrupt; got != want {
+		t.Fatalf("got %v; want %v", got, want)
+	}
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v; want %v", err, context.Canceled)
+	}
+	if got, want := serr.ExitCode(), 130; got != want {
+		t.Fatalf("got %d; want %d", got, want)
+	}
+	if got, want := sig, os.Interrupt; got != want {
+		t.Fatalf("got %v; want %v", got, want)
+	}
+
+	// No signal.
+	if err := c.Parse([]string{}...); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,84 @@
+package cmdflag
+
+import (
+	"sort"
+	"strings"
+)
+
+// maxSuggestDistance is the maximum edit distance for a command name to be suggested.
+const maxSuggestDistance = 2
+
+// suggest returns the names of the subcommands of c that are close to name,
+// closest first. Commands starting with name come first.
+func (c *Command) suggest(name string) []string {
+	maxDist := len(name) / 2
+	if maxDist > maxSuggestDistance {
+		maxDist = maxSuggestDistance
+	}
+	type suggestion struct {
+		name string
+		dist int
+	}
+	var found []suggestion
+	for _, sub := range c.subs {
+		app := &sub.Application
+		dist := -1
+		for _, n := range append([]string{app.Name}, app.Aliases...) {
+			d := distance(name, n)
+			if name != "" && strings.HasPrefix(n, name) {
+				d = 0
+			} else if d > maxDist {
+				continue
+			}
+			if dist < 0 || d < dist {
+				dist = d
+			}
+		}
+		if dist >= 0 {
+			found = append(found, suggestion{app.Name, dist})
+		}
+	}
+	sort.SliceStable(found, func(i, j int) bool {
+		if found[i].dist != found[j].dist {
+			return found[i].dist < found[j].dist
+		}
+		return found[i].name < found[j].name
+	})
+	names := make([]string, len(found))
+	for i, s := range found {
+		names[i] = s.name
+	}
+	return names
+}
+
+// distance returns the Levenshtein distance between a and b.
+func distance(a, b string) int {
+	s, t := []rune(a), []rune(b)
+	prev := make([]int, len(t)+1)
+	cur := make([]int, len(t)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(s); i++ {
+		cur[0] = i
+		for j := 1; j <= len(t); j++ {
+			cost := 1
+			if s[i-1] == t[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(t)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+	return a
+}
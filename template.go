@@ -0,0 +1,283 @@
+package cmdflag
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// DefaultUsageTemplate is the template used to display the usage of a command with its flags,
+// and the list of its subcommands. Subcommands are also described with their expected arguments.
+// It is executed with a *UsageData.
+const DefaultUsageTemplate = "Usage of {{if .Top}}{{.Name}}{{else}}command `{{.DisplayName}}`{{end}}:\n" +
+	"{{if not .Top}}{{with .Descr}}{{.}}\n{{end}}{{range .Parents}}{{.}} {{end}}{{.Name}}{{with .Synopsis}} {{.}}{{end}}\n{{end}}" +
+	"{{range .Flags}}{{.}}\n{{end}}" +
+	"{{if .GlobalFlags}}\nGlobal flags:\n{{range .GlobalFlags}}{{.}}\n{{end}}{{end}}" +
+	"{{if .FlagGroups}}\nFlag constraints:\n{{range .FlagGroups}}  {{.}}\n{{end}}{{end}}" +
+	"{{if .Commands}}\nSubcommands:\n{{.CommandTable}}{{end}}"
+
+// DefaultHelpTemplate is the template used by the help command to display a command.
+// It is executed with a *UsageData.
+const DefaultHelpTemplate = "{{.Descr}}\n{{range .Parents}}{{.}} {{end}}{{.DisplayName}} {{.Synopsis}}\n{{.Help}}\n" +
+	"{{if .Flags}}\nFlags:\n{{range .Flags}}{{.}}\n{{end}}{{end}}" +
+	"{{if .GlobalFlags}}\nGlobal flags:\n{{range .GlobalFlags}}{{.}}\n{{end}}{{end}}" +
+	"{{if .FlagGroups}}\nFlag constraints:\n{{range .FlagGroups}}  {{.}}\n{{end}}{{end}}" +
+	"{{if .Commands}}\nSubcommands:\n{{.CommandTable}}{{end}}"
+
+var (
+	defaultUsageTemplate = template.Must(template.New("usage").Parse(DefaultUsageTemplate))
+	defaultHelpTemplate  = template.Must(template.New("help").Parse(DefaultHelpTemplate))
+)
+
+// UsageData describes a command to the usage and help templates.
+type UsageData struct {
+	Path        []string     // Names of the commands leading to the command, starting with the program
+	Name        string       // Command name
+	Aliases     []string     // Alternative command names
+	Top         bool         // Whether the command is the top level one
+	Descr       string       // Short description
+	Synopsis    string       // Expected arguments, from Application.Params or Application.Args
+	Help        string       // Long description
+	Group       string       // Heading the command is listed under
+	Flags       []FlagUsage  // Flags of the command
+	GlobalFlags []FlagUsage  // Flags inherited from the parent commands
+	FlagGroups  []FlagGroup  // Constraints on the flags
+	Commands    []*UsageData // Subcommands sorted by group and name, without their flags nor their own subcommands
+	Width       int          // Width of the output, in columns
+}
+
+// Parents returns the names of the commands leading to the command, excluding it.
+func (d *UsageData) Parents() []string {
+	return d.Path[:len(d.Path)-1]
+}
+
+// DisplayName returns the command name followed by its aliases, e.g. "remove (rm, del)".
+func (d *UsageData) DisplayName() string {
+	if len(d.Aliases) == 0 {
+		return d.Name
+	}
+	return fmt.Sprintf("%s (%s)", d.Name, strings.Join(d.Aliases, ", "))
+}
+
+// CommandTable returns the subcommands names and descriptions in aligned columns,
+// the descriptions being wrapped to fit the output width.
+// Subcommands with a group are listed under its heading, after the ones without.
+func (d *UsageData) CommandTable() string {
+	var names []string
+	var pad int
+	for _, sub := range d.Commands {
+		name := sub.DisplayName()
+		names = append(names, name)
+		if len(name) > pad {
+			pad = len(name)
+		}
+	}
+	indent := strings.Repeat(" ", 2+pad+2)
+	width := d.Width
+	if width <= 0 {
+		width = defaultWidth
+	}
+	// Keep descriptions readable on narrow outputs.
+	width -= len(indent)
+	if width < 20 {
+		width = 20
+	}
+	var buf strings.Builder
+	for i, sub := range d.Commands {
+		if sub.Group != "" && (i == 0 || sub.Group != d.Commands[i-1].Group) {
+			if i > 0 {
+				buf.WriteString("\n")
+			}
+			_, _ = fmt.Fprintf(&buf, "%s:\n", sub.Group)
+		}
+		lines := wrap(sub.Descr, width)
+		if len(lines) == 0 {
+			_, _ = fmt.Fprintf(&buf, "  %s\n", names[i])
+			continue
+		}
+		_, _ = fmt.Fprintf(&buf, "  %-*s  %s\n", pad, names[i], lines[0])
+		for _, l := range lines[1:] {
+			buf.WriteString(indent)
+			buf.WriteString(l)
+			buf.WriteString("\n")
+		}
+	}
+	return buf.String()
+}
+
+// wrap splits s into lines of at most width characters, breaking at spaces.
+// Words longer than width are not broken.
+func wrap(s string, width int) []string {
+	var lines []string
+	var line string
+	for _, w := range strings.Fields(s) {
+		switch {
+		case line == "":
+			line = w
+		case len(line)+1+len(w) <= width:
+			line += " " + w
+		default:
+			lines = append(lines, line)
+			line = w
+		}
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// FlagUsage describes a flag, or a set of flags sharing the same value (see AliasFlag).
+type FlagUsage struct {
+	Names    []string // Flag names with their dashes, shortest first
+	Arg      string   // Name of the flag argument, if any
+	Usage    string   // Flag usage, without the argument quotes
+	Default  string   // Default value, quoted for strings, or empty if it is the zero value
+	Required bool     // Whether the flag must be set
+}
+
+// String formats the flag as flag.PrintDefaults does.
+func (f FlagUsage) String() string {
+	var buf strings.Builder
+	buf.WriteString("  ")
+	buf.WriteString(strings.Join(f.Names, ", "))
+	if f.Arg != "" {
+		buf.WriteString(" ")
+		buf.WriteString(f.Arg)
+	}
+	// Boolean flags of one ASCII letter are so common we
+	// treat them specially, putting their usage on the same line.
+	if buf.Len() <= 4 {
+		buf.WriteString("\t")
+	} else {
+		buf.WriteString("\n    \t")
+	}
+	buf.WriteString(strings.Replace(f.Usage, "\n", "\n    \t", -1))
+	if f.Default != "" {
+		_, _ = fmt.Fprintf(&buf, " (default %s)", f.Default)
+	}
+	if f.Required {
+		buf.WriteString(" (required)")
+	}
+	return buf.String()
+}
+
+// usageData returns the description of c with its flags fs, and of its subcommands,
+// to be displayed on out.
+func (c *Command) usageData(out io.Writer, fs *flag.FlagSet) *UsageData {
+	d := c.data(fs)
+	d.Width = termWidth(out)
+	for _, sub := range c.Commands() {
+		d.Commands = append(d.Commands, sub.data(nil))
+	}
+	// Commands may be added in any order, e.g. from several init functions.
+	sort.Slice(d.Commands, func(i, j int) bool {
+		a, b := d.Commands[i], d.Commands[j]
+		if a.Group != b.Group {
+			return a.Group < b.Group
+		}
+		return a.Name < b.Name
+	})
+	return d
+}
+
+// data returns the description of c with its flags fs, if any.
+func (c *Command) data(fs *flag.FlagSet) *UsageData {
+	app := &c.Application
+	if fs == nil {
+		return &UsageData{
+			Path:     c.Path(),
+			Name:     app.Name,
+			Aliases:  app.Aliases,
+			Descr:    app.Descr,
+			Synopsis: app.synopsis(),
+			Help:     app.Help,
+			Group:    app.Group,
+		}
+	}
+	return &UsageData{
+		Path:        c.Path(),
+		Name:        app.Name,
+		Aliases:     app.Aliases,
+		Top:         c.parent == nil,
+		Descr:       app.Descr,
+		Synopsis:    app.synopsis(),
+		Help:        app.Help,
+		Group:       app.Group,
+		Flags:       flagUsages(fs, app, func(f *flag.Flag) bool { return !c.inherited(f) }),
+		GlobalFlags: flagUsages(fs, app, c.inherited),
+		FlagGroups:  app.FlagGroups,
+	}
+}
+
+// usageTemplate returns the usage template of c, inherited from its parents if not set.
+func (c *Command) usageTemplate() *template.Template {
+	for ; c != nil; c = c.parent {
+		if c.UsageTemplate != nil {
+			return c.UsageTemplate
+		}
+	}
+	return defaultUsageTemplate
+}
+
+// helpTemplate returns the help template of c, inherited from its parents if not set.
+func (c *Command) helpTemplate() *template.Template {
+	for ; c != nil; c = c.parent {
+		if c.HelpTemplate != nil {
+			return c.HelpTemplate
+		}
+	}
+	return defaultHelpTemplate
+}
+
+// flagUsages returns the description of the flags of fs for which keep returns true.
+// Flags sharing the same value (see AliasFlag) are described together, shortest name first.
+// Long flag names are prefixed with a double dash for GNU style applications.
+func flagUsages(fs *flag.FlagSet, app *Application, keep func(*flag.Flag) bool) []FlagUsage {
+	var groups [][]*flag.Flag
+	fs.VisitAll(func(f *flag.Flag) {
+		if !keep(f) {
+			return
+		}
+		for i, g := range groups {
+			if sameValue(g[0].Value, f.Value) {
+				groups[i] = append(g, f)
+				return
+			}
+		}
+		groups = append(groups, []*flag.Flag{f})
+	})
+	usages := make([]FlagUsage, 0, len(groups))
+	for _, g := range groups {
+		sort.SliceStable(g, func(i, j int) bool { return len(g[i].Name) < len(g[j].Name) })
+		usages = append(usages, flagUsage(g, app))
+	}
+	return usages
+}
+
+// flagUsage returns the description of the flags sharing the same value.
+func flagUsage(names []*flag.Flag, app *Application) FlagUsage {
+	var u FlagUsage
+	for _, f := range names {
+		dash := "-"
+		if app.GNU && len(f.Name) > 1 {
+			dash = "--"
+		}
+		u.Names = append(u.Names, dash+f.Name)
+	}
+	f := names[0]
+	u.Arg, u.Usage = flag.UnquoteUsage(f)
+	if !isZeroValue(f) {
+		u.Default = f.DefValue
+		if v, ok := f.Value.(flag.Getter); ok {
+			if _, ok := v.Get().(string); ok {
+				u.Default = fmt.Sprintf("%q", f.DefValue)
+			}
+		}
+	}
+	u.Required = app.isRequired(names)
+	return u
+}
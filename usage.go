@@ -4,29 +4,33 @@ import (
 	"flag"
 	"fmt"
 	"io"
+	"reflect"
 )
 
-// usage returns the default function used to display the help message.
-func usage(out io.Writer, c *Command) func() {
+// usage returns the default function used to display the help message of c with its flags fs.
+func usage(out io.Writer, c *Command, fs *flag.FlagSet) func() {
 	return func() {
-		name := c.Application.Name
-		if c.Application.Init != nil {
-			// Not the program.
-			name = "command `" + name + "`"
+		if err := c.usageTemplate().Execute(out, c.usageData(out, fs)); err != nil {
+			_, _ = fmt.Fprintln(out, err)
 		}
-		_, _ = fmt.Fprintf(out, "Usage of %s:\n", name)
-		c.fset.PrintDefaults()
+	}
+}
 
-		if cmds := c.Commands(); len(cmds) > 0 {
-			_, _ = fmt.Fprintf(out, "\nSubcommands:\n")
-			for _, c := range cmds {
-				app := c.Application
-				_, _ = fmt.Fprintf(out, "Usage of command `%s`:\n", app.Name)
-				_, _ = fmt.Fprintf(out, "%s\n%s %s\n", app.Descr, app.Name, app.Args)
-				fs := flag.NewFlagSet(app.Name, app.Err)
-				_ = app.Init(fs)
-				fs.PrintDefaults()
-			}
+// isZeroValue returns whether the flag default value is the zero value of its type.
+func isZeroValue(f *flag.Flag) (ok bool) {
+	defer func() {
+		// Some values may not support being zero.
+		if recover() != nil {
+			ok = false
 		}
+	}()
+	typ := reflect.TypeOf(f.Value)
+	var z reflect.Value
+	if typ.Kind() == reflect.Ptr {
+		z = reflect.New(typ.Elem())
+	} else {
+		z = reflect.Zero(typ)
 	}
+	v, ok := z.Interface().(flag.Value)
+	return ok && f.DefValue == v.String()
 }
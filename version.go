@@ -20,9 +20,15 @@ const (
 	FullVersionBoolFlag = "fullversion"
 )
 
-// hasBoolFlag returns whether or not the flag with name `name` was defined and set to true.
+// hasBoolFlag returns whether or not the flag with name `name` was set to true when parsing fset.
 func hasBoolFlag(fset *flag.FlagSet, name string) bool {
-	f := fset.Lookup(name)
+	var f *flag.Flag
+	// Only consider the flags set by the parse, as the flag values may be shared.
+	fset.Visit(func(ff *flag.Flag) {
+		if ff.Name == name {
+			f = ff
+		}
+	})
 	if f == nil {
 		return false
 	}
@@ -36,6 +42,17 @@ func hasBoolFlag(fset *flag.FlagSet, name string) bool {
 	return ok && b
 }
 
+// builtin returns the function displaying the builtin flag set in fset, or nil if none is set.
+func builtin(fset *flag.FlagSet) func(io.Writer) {
+	switch {
+	case hasBoolFlag(fset, VersionBoolFlag):
+		return version
+	case hasBoolFlag(fset, FullVersionBoolFlag):
+		return fullversion
+	}
+	return nil
+}
+
 // program returns a deterministic name for the running program.
 func program() string {
 	return strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe")
@@ -0,0 +1,24 @@
+package cmdflag
+
+import (
+	"io"
+	"os"
+	"strconv"
+)
+
+// defaultWidth is the output width used when it cannot be determined.
+const defaultWidth = 80
+
+// termWidth returns the width of the terminal out is written to, from the COLUMNS
+// environment variable or the terminal itself, or defaultWidth.
+func termWidth(out io.Writer) int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	if f, ok := out.(*os.File); ok {
+		if n := fileWidth(f); n > 0 {
+			return n
+		}
+	}
+	return defaultWidth
+}
@@ -0,0 +1,10 @@
+// +build !linux
+
+package cmdflag
+
+import "os"
+
+// fileWidth returns 0 as the terminal width is only available on Linux.
+func fileWidth(*os.File) int {
+	return 0
+}